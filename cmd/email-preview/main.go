@@ -0,0 +1,43 @@
+// Command email-preview renders a transactional email with sample data and
+// writes it to stdout, for iterating on templates without running the API.
+//
+//	go run ./cmd/email-preview -template race_results -locale es -format html
+//	go run ./cmd/email-preview -dir internal/mailer/templates -template verification
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"ergracer-api/internal/mailer"
+)
+
+func main() {
+	dir := flag.String("dir", "", "template directory (defaults to the embedded templates)")
+	name := flag.String("template", mailer.TemplateVerification, "template to render")
+	locale := flag.String("locale", mailer.DefaultLocale, "locale to render")
+	format := flag.String("format", "text", "output format: text or html")
+	flag.Parse()
+
+	data, ok := mailer.SampleData(*name)
+	if !ok {
+		log.Fatalf("Unknown email template %q", *name)
+	}
+
+	msg, err := mailer.NewTemplates(*dir).Render(*name, *locale, "preview@example.com", data)
+	if err != nil {
+		log.Fatal("Failed to render email:", err)
+	}
+
+	switch *format {
+	case "text":
+		fmt.Printf("Subject: %s\n\n%s", msg.Subject, msg.Text)
+	case "html":
+		fmt.Print(msg.HTML)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"ergracer-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultJobRunsLimit = 20
+	maxJobRunsLimit     = 200
+)
+
+type AdminHandler struct {
+	jobRunner *services.JobRunner
+}
+
+func NewAdminHandler(jobRunner *services.JobRunner) *AdminHandler {
+	return &AdminHandler{jobRunner: jobRunner}
+}
+
+func (h *AdminHandler) GetJobs(c *gin.Context) {
+	jobs, err := h.jobRunner.Jobs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+func (h *AdminHandler) GetJobRuns(c *gin.Context) {
+	jobName := c.Param("name")
+	if !h.jobRunner.HasJob(jobName) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	limit := defaultJobRunsLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxJobRunsLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+			return
+		}
+	}
+
+	runs, err := h.jobRunner.GetJobRuns(jobName, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job runs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
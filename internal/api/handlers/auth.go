@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"net/http"
 
 	"ergracer-api/internal/config"
+	"ergracer-api/internal/logging"
+	"ergracer-api/internal/mailer"
 	"ergracer-api/internal/services"
 	"ergracer-api/internal/utils"
 
@@ -14,13 +18,15 @@ import (
 type AuthHandler struct {
 	userService    *services.UserService
 	sessionService *services.SessionService
+	emailService   *services.EmailService
 	config         *config.Config
 }
 
-func NewAuthHandler(userService *services.UserService, sessionService *services.SessionService, config *config.Config) *AuthHandler {
+func NewAuthHandler(userService *services.UserService, sessionService *services.SessionService, emailService *services.EmailService, config *config.Config) *AuthHandler {
 	return &AuthHandler{
 		userService:    userService,
 		sessionService: sessionService,
+		emailService:   emailService,
 		config:         config,
 	}
 }
@@ -29,6 +35,7 @@ type RegisterRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Username string `json:"username" binding:"required,min=3,max=50"`
 	Password string `json:"password" binding:"required,min=6"`
+	Locale   string `json:"locale"`
 }
 
 type LoginRequest struct {
@@ -36,6 +43,36 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+type ChangeEmailRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ChangeUsernameRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=50"`
+}
+
+type SetLocaleRequest struct {
+	Locale string `json:"locale" binding:"required"`
+}
+
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
 }
@@ -53,24 +90,28 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userService.CreateUser(req.Email, req.Username, req.Password)
+	locale := req.Locale
+	if locale == "" {
+		locale = c.GetHeader("Accept-Language")
+	}
+
+	user, err := h.userService.CreateUser(c.Request.Context(), req.Email, req.Username, req.Password, mailer.NormalizeLocale(locale))
 	if err != nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "User already exists or invalid data"})
 		return
 	}
 
 	if user.EmailVerifyToken != nil {
-		err = utils.SendVerificationEmail(
-			user.Email,
-			*user.EmailVerifyToken,
-			h.config.AppURL(),
-			h.config.MailgunDomain(),
-			h.config.MailgunAPIKey(),
-			h.config.MailgunFromEmail(),
-			h.config.MailgunFromName(),
-		)
+		err = h.emailService.SendVerification(c.Request.Context(), user.Email, user.Locale, *user.EmailVerifyToken)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+			// The account exists either way; the client can ask for the
+			// email again through the resend endpoint.
+			logging.FromContext(c.Request.Context()).Error("failed to send verification email",
+				"recipient_id", user.ID, "error", err)
+			c.JSON(http.StatusCreated, gin.H{
+				"message": "User created successfully, but the verification email could not be sent. Please request a new one.",
+				"user":    user,
+			})
 			return
 		}
 	}
@@ -88,20 +129,15 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userService.AuthenticateUser(req.Email, req.Password)
+	user, err := h.userService.AuthenticateUser(c.Request.Context(), req.Email, req.Password)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
-	accessToken, err := utils.GenerateJWT(user.ID, h.config.JWTSecret())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate access token"})
-		return
-	}
-
 	deviceType := utils.DetectDeviceType(c.GetHeader("User-Agent"))
-	refreshToken, err := h.sessionService.CreateSession(
+	refreshToken, sessionID, err := h.sessionService.CreateSession(
+		c.Request.Context(),
 		user.ID,
 		deviceType,
 		c.GetHeader("User-Agent"),
@@ -112,6 +148,12 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	accessToken, err := utils.GenerateJWT(user.ID, sessionID, h.config.JWTSecret())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate access token"})
+		return
+	}
+
 	c.JSON(http.StatusOK, AuthResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
@@ -126,8 +168,15 @@ func (h *AuthHandler) VerifyEmail(c *gin.Context) {
 		return
 	}
 
-	err := h.userService.VerifyEmail(token)
+	err := h.userService.VerifyEmail(c.Request.Context(), token)
 	if err != nil {
+		if errors.Is(err, services.ErrVerificationTokenExpired) {
+			c.JSON(http.StatusGone, gin.H{
+				"error": "Verification link has expired, please request a new one",
+				"code":  "verification_token_expired",
+			})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -135,6 +184,78 @@ func (h *AuthHandler) VerifyEmail(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
 }
 
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Unknown, already verified and throttled accounts all get the same
+	// answer so the endpoint cannot be used to probe for accounts.
+	user, token, err := h.userService.ResendVerification(c.Request.Context(), req.Email)
+	if err == nil {
+		err = h.emailService.SendVerification(c.Request.Context(), user.Email, user.Locale, token)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error("failed to resend verification email",
+				"recipient_id", user.ID, "error", err)
+		}
+	} else if err != sql.ErrNoRows && !errors.Is(err, services.ErrAlreadyVerified) && !errors.Is(err, services.ErrVerificationResendTooSoon) {
+		logging.FromContext(c.Request.Context()).Error("failed to resend verification email", "error", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "If an unverified account exists for that email, a new verification link has been sent.",
+	})
+}
+
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, token, err := h.userService.CreatePasswordResetToken(c.Request.Context(), req.Email)
+	if err != nil && err != sql.ErrNoRows {
+		logging.FromContext(c.Request.Context()).Error("failed to create password reset token", "error", err)
+	}
+
+	if err == nil {
+		// Send in the background so the response time does not reveal
+		// whether the account exists.
+		h.emailService.SendInBackground(c.Request.Context(), "password reset email", func(ctx context.Context) error {
+			return h.emailService.SendPasswordReset(ctx, user.Email, user.Locale, token)
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "If an account exists for that email, a password reset link has been sent.",
+	})
+}
+
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := h.userService.ResetPassword(c.Request.Context(), req.Token, req.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	err = h.sessionService.DeleteUserSessions(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password was reset but sessions could not be revoked"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully. Please log in with your new password."})
+}
+
 func (h *AuthHandler) GetProfile(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -142,15 +263,155 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userService.GetUserByID(userID.(int))
+	user, err := h.userService.GetUserByID(c.Request.Context(), userID.(int))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.userService.ChangePassword(c.Request.Context(), userID.(int), req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		if errors.Is(err, services.ErrIncorrectPassword) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		}
+		return
+	}
+
+	// Keep the device that changed the password signed in, but log out
+	// everything else in case the old password was compromised.
+	err = h.sessionService.DeleteOtherUserSessions(c.Request.Context(), userID.(int), c.GetInt("session_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password was changed but other sessions could not be revoked"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+}
+
+func (h *AuthHandler) ChangeEmail(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userService.GetUserByID(c.Request.Context(), userID.(int))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
+	token, err := h.userService.RequestEmailChange(c.Request.Context(), user.ID, req.Email)
+	if err != nil {
+		if errors.Is(err, services.ErrEmailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email address is already in use"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		}
+		return
+	}
+
+	err = h.emailService.SendVerification(c.Request.Context(), req.Email, user.Locale, token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Please check your new email address for verification. Your current email stays active until then.",
+	})
+}
+
+func (h *AuthHandler) ChangeUsername(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req ChangeUsernameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.userService.ChangeUsername(c.Request.Context(), userID.(int), req.Username)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUsernameTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken"})
+		case errors.Is(err, services.ErrUsernameCooldown):
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Username can only be changed once every 30 days"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change username"})
+		}
+		return
+	}
+
+	user, err := h.userService.GetUserByID(c.Request.Context(), userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
 	c.JSON(http.StatusOK, user)
 }
 
+func (h *AuthHandler) SetLocale(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req SetLocaleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !mailer.IsSupportedLocale(req.Locale) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "Unsupported locale",
+			"supported_locales": mailer.SupportedLocales,
+		})
+		return
+	}
+
+	locale := mailer.NormalizeLocale(req.Locale)
+	err := h.userService.SetLocale(c.Request.Context(), userID.(int), locale)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set locale"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"locale": locale})
+}
+
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	var req RefreshRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -158,9 +419,11 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	session, err := h.sessionService.ValidateRefreshToken(req.RefreshToken)
+	session, err := h.sessionService.ValidateRefreshToken(c.Request.Context(), req.RefreshToken)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, services.ErrRefreshTokenReused) {
+			refreshTokenReused(c)
+		} else if err == sql.ErrNoRows {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate refresh token"})
@@ -168,7 +431,7 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	newAccessToken, err := utils.GenerateJWT(session.UserID, h.config.JWTSecret())
+	newAccessToken, err := utils.GenerateJWT(session.UserID, session.ID, h.config.JWTSecret())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate access token"})
 		return
@@ -180,9 +443,13 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	err = h.sessionService.UpdateSession(session.ID, newRefreshToken)
+	err = h.sessionService.UpdateSession(c.Request.Context(), session, newRefreshToken)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update session"})
+		if errors.Is(err, services.ErrRefreshTokenReused) {
+			refreshTokenReused(c)
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update session"})
+		}
 		return
 	}
 
@@ -190,4 +457,15 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		"access_token":  newAccessToken,
 		"refresh_token": newRefreshToken,
 	})
-}
\ No newline at end of file
+}
+
+// refreshTokenReused tells the client its session was revoked because a
+// refresh token was used twice. The distinct code lets clients drop their
+// stored tokens and send the user back to the login screen.
+func refreshTokenReused(c *gin.Context) {
+	logging.FromContext(c.Request.Context()).Warn("refresh token reuse detected", "client_ip", c.ClientIP())
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"error": "Refresh token has already been used, please log in again",
+		"code":  "refresh_token_reused",
+	})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+
+	"ergracer-api/internal/mailer"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EmailPreviewHandler renders transactional emails with sample data so
+// template changes can be checked in a browser.
+type EmailPreviewHandler struct {
+	templates *mailer.Templates
+}
+
+func NewEmailPreviewHandler(templates *mailer.Templates) *EmailPreviewHandler {
+	return &EmailPreviewHandler{templates: templates}
+}
+
+func (h *EmailPreviewHandler) PreviewEmail(c *gin.Context) {
+	name := c.Param("template")
+
+	data, ok := mailer.SampleData(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown email template"})
+		return
+	}
+
+	msg, err := h.templates.Render(name, c.Query("locale"), "preview@example.com", data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("X-Email-Subject", msg.Subject)
+	if c.Query("format") == "text" {
+		c.String(http.StatusOK, msg.Text)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(msg.HTML))
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 	"strconv"
 
@@ -12,12 +13,14 @@ import (
 type FriendsHandler struct {
 	friendshipService *services.FriendshipService
 	userService       *services.UserService
+	emailService      *services.EmailService
 }
 
-func NewFriendsHandler(friendshipService *services.FriendshipService, userService *services.UserService) *FriendsHandler {
+func NewFriendsHandler(friendshipService *services.FriendshipService, userService *services.UserService, emailService *services.EmailService) *FriendsHandler {
 	return &FriendsHandler{
 		friendshipService: friendshipService,
 		userService:       userService,
+		emailService:      emailService,
 	}
 }
 
@@ -43,18 +46,24 @@ func (h *FriendsHandler) InviteFriend(c *gin.Context) {
 		return
 	}
 
-	_, err := h.userService.GetUserByID(req.FriendID)
+	_, err := h.userService.GetUserByID(c.Request.Context(), req.FriendID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	err = h.friendshipService.InviteFriend(userID.(int), req.FriendID)
+	created, err := h.friendshipService.InviteFriend(c.Request.Context(), userID.(int), req.FriendID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if created {
+		h.emailService.SendInBackground(c.Request.Context(), "friend request email", func(ctx context.Context) error {
+			return h.emailService.SendFriendRequest(ctx, userID.(int), req.FriendID)
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Friend invitation sent"})
 }
 
@@ -72,7 +81,7 @@ func (h *FriendsHandler) AcceptFriendship(c *gin.Context) {
 		return
 	}
 
-	err = h.friendshipService.AcceptFriendship(userID.(int), friendID)
+	err = h.friendshipService.AcceptFriendship(c.Request.Context(), userID.(int), friendID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -88,7 +97,7 @@ func (h *FriendsHandler) GetFriends(c *gin.Context) {
 		return
 	}
 
-	friends, err := h.friendshipService.GetFriends(userID.(int))
+	friends, err := h.friendshipService.GetFriends(c.Request.Context(), userID.(int))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get friends"})
 		return
@@ -104,7 +113,7 @@ func (h *FriendsHandler) GetPendingInvitations(c *gin.Context) {
 		return
 	}
 
-	invitations, err := h.friendshipService.GetPendingInvitations(userID.(int))
+	invitations, err := h.friendshipService.GetPendingInvitations(c.Request.Context(), userID.(int))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get pending invitations"})
 		return
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"ergracer-api/internal/buildinfo"
+	"ergracer-api/internal/config"
+	"ergracer-api/internal/database"
+	"ergracer-api/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout bounds the database checks of a readiness probe.
+const readinessTimeout = 2 * time.Second
+
+type HealthHandler struct {
+	db         *sql.DB
+	mailDriver string
+	draining   func() bool
+}
+
+// NewHealthHandler returns the probe handlers. draining reports whether the
+// server is shutting down and should receive no new traffic.
+func NewHealthHandler(db *sql.DB, mailDriver string, draining func() bool) *HealthHandler {
+	return &HealthHandler{db: db, mailDriver: mailDriver, draining: draining}
+}
+
+type healthCheck struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Version *int   `json:"version,omitempty"`
+	Latest  *int   `json:"latest,omitempty"`
+	Driver  string `json:"driver,omitempty"`
+}
+
+// Liveness reports that the process is up. It checks no dependencies, so a
+// database outage does not get healthy replicas restarted.
+func (h *HealthHandler) Liveness(c *gin.Context) {
+	respondProbe(c, http.StatusOK, gin.H{
+		"status": "ok",
+		"build":  buildinfo.Get(),
+	})
+}
+
+// Readiness reports whether the server should receive traffic: the database
+// answers, its schema is at least as new as this build expects, and the
+// server is not draining. The mail check is informational; the log driver
+// does not make the server unready.
+func (h *HealthHandler) Readiness(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]healthCheck{
+		"database":   h.checkDatabase(ctx),
+		"migrations": h.checkMigrations(ctx),
+		"mail":       h.checkMail(),
+	}
+
+	status := "ready"
+	code := http.StatusOK
+	if checks["database"].Status != "ok" || checks["migrations"].Status != "ok" {
+		status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+	if h.draining() {
+		status = "draining"
+		code = http.StatusServiceUnavailable
+	}
+
+	respondProbe(c, code, gin.H{
+		"status": status,
+		"checks": checks,
+		"build":  buildinfo.Get(),
+	})
+}
+
+func (h *HealthHandler) checkDatabase(ctx context.Context) healthCheck {
+	if err := h.db.PingContext(ctx); err != nil {
+		logging.FromContext(ctx).Warn("readiness check: database ping failed", "error", err)
+		return healthCheck{Status: "error", Error: "database unreachable"}
+	}
+	return healthCheck{Status: "ok"}
+}
+
+func (h *HealthHandler) checkMigrations(ctx context.Context) healthCheck {
+	migrations, err := database.Migrations()
+	if err != nil {
+		return healthCheck{Status: "error", Error: err.Error()}
+	}
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].Version
+	}
+
+	version, err := database.SchemaVersion(ctx, h.db)
+	if err != nil {
+		logging.FromContext(ctx).Warn("readiness check: reading schema version failed", "error", err)
+		return healthCheck{Status: "error", Error: "schema version unavailable", Latest: &latest}
+	}
+
+	check := healthCheck{Status: "ok", Version: &version, Latest: &latest}
+	if version < latest {
+		check.Status = "pending"
+	}
+	return check
+}
+
+func (h *HealthHandler) checkMail() healthCheck {
+	if h.mailDriver == config.MailDriverLog {
+		return healthCheck{Status: "log_only", Driver: h.mailDriver}
+	}
+	return healthCheck{Status: "ok", Driver: h.mailDriver}
+}
+
+// respondProbe writes a probe result, leaving out the body for HEAD requests.
+func respondProbe(c *gin.Context, code int, body gin.H) {
+	if c.Request.Method == http.MethodHead {
+		c.Status(code)
+		return
+	}
+	c.JSON(code, body)
+}
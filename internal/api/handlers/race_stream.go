@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ergracer-api/internal/logging"
+	"ergracer-api/internal/models"
+	"ergracer-api/internal/services"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	streamWriteWait      = 10 * time.Second
+	streamPongWait       = 60 * time.Second
+	streamPingPeriod     = (streamPongWait * 9) / 10
+	streamMaxMessageSize = 1024
+
+	// sseKeepAlivePeriod keeps idle event streams from being cut by proxies.
+	sseKeepAlivePeriod = 15 * time.Second
+	// sseReconnectDelay is the retry hint sent to event stream clients when
+	// the server shuts down, giving a replacement time to come up.
+	sseReconnectDelay = 2 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// Clients authenticate with a bearer token, so any origin may connect,
+	// matching the CORS policy of the HTTP API.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// RaceStreamMessage is a message sent by a client over the race socket.
+type RaceStreamMessage struct {
+	Type     string `json:"type"`
+	Distance int    `json:"distance"`
+}
+
+type raceStreamError struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+// StreamRace upgrades the request to a WebSocket that pushes every event of
+// the race as it happens, starting with a snapshot of the current state.
+// Participants may send {"type": "progress", "distance": N} messages on the
+// same socket instead of calling the progress endpoint.
+func (h *RacesHandler) StreamRace(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	race, err := h.raceService.GetRaceByUUID(c.Request.Context(), c.Param("uuid"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Race not found"})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already replied with an HTTP error.
+		return
+	}
+	defer conn.Close()
+
+	events, unsubscribe := h.raceService.Subscribe(race.ID)
+	defer unsubscribe()
+
+	snapshot, err := h.raceSnapshot(c.Request.Context(), race.UUID)
+	if err != nil {
+		closeStream(conn, websocket.CloseInternalServerErr, "Failed to load race")
+		return
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+	if err := conn.WriteJSON(snapshot); err != nil {
+		return
+	}
+
+	replies := make(chan raceStreamError, 8)
+	done := make(chan struct{})
+	go h.readRaceStream(c.Request.Context(), conn, race.ID, userID.(int), replies, done)
+	defer func() {
+		// Let a progress update sent on the socket finish before the
+		// subscription is released, so shutdown waits for it.
+		conn.Close()
+		<-done
+	}()
+
+	ticker := time.NewTicker(streamPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case event, ok := <-events:
+			if !ok {
+				if h.raceService.ShuttingDown() {
+					closeStream(conn, websocket.CloseServiceRestart, "Server restarting, reconnect")
+				} else {
+					closeStream(conn, websocket.CloseTryAgainLater, "Client too slow, reconnect")
+				}
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case reply := <-replies:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteJSON(reply); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readRaceStream handles messages sent by the client until the connection
+// fails, then closes done. Errors are handed to the writer through replies
+// because a WebSocket connection supports only one concurrent writer.
+func (h *RacesHandler) readRaceStream(ctx context.Context, conn *websocket.Conn, raceID, userID int, replies chan<- raceStreamError, done chan<- struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(streamMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg RaceStreamMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			reply(replies, "Invalid message")
+			continue
+		}
+
+		switch msg.Type {
+		case "progress":
+			if msg.Distance < 0 {
+				reply(replies, "Invalid distance")
+				continue
+			}
+			err := h.raceService.UpdateRaceProgress(ctx, raceID, userID, msg.Distance)
+			if errors.Is(err, services.ErrNotRaceParticipant) {
+				reply(replies, "Only participants can send progress")
+			} else if errors.Is(err, models.ErrIllegalTransition) {
+				reply(replies, err.Error())
+			} else if err != nil {
+				reply(replies, "Failed to update progress")
+			}
+		default:
+			reply(replies, "Unknown message type")
+		}
+	}
+}
+
+// RaceEvents streams the same events as StreamRace as Server-Sent Events for
+// clients that cannot hold a WebSocket. Progress events carry their
+// race_updates ID, so a client reconnecting with Last-Event-ID is first sent
+// the progress it missed, then a fresh snapshot, then live events.
+func (h *RacesHandler) RaceEvents(c *gin.Context) {
+	race, err := h.raceService.GetRaceByUUID(c.Request.Context(), c.Param("uuid"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Race not found"})
+		return
+	}
+
+	lastEventID := 0
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		lastEventID, err = strconv.Atoi(header)
+		if err != nil || lastEventID < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+			return
+		}
+	}
+
+	events, unsubscribe := h.raceService.Subscribe(race.ID)
+	defer unsubscribe()
+
+	var missed []models.RaceUpdate
+	if lastEventID > 0 {
+		missed, err = h.raceService.GetRaceUpdatesSince(c.Request.Context(), race.ID, lastEventID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load race updates"})
+			return
+		}
+	}
+
+	snapshot, err := h.raceSnapshot(c.Request.Context(), race.UUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load race"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	// The stream stays open far longer than the server's write timeout.
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		logging.FromContext(c.Request.Context()).Warn("failed to clear write deadline for race event stream", "error", err)
+	}
+
+	for _, update := range missed {
+		renderEvent(c, models.RaceEvent{
+			ID:        update.ID,
+			Type:      models.RaceEventProgress,
+			RaceID:    update.RaceID,
+			UserID:    update.UserID,
+			Data:      gin.H{"distance": update.Distance},
+			Timestamp: update.Timestamp,
+		})
+		lastEventID = update.ID
+	}
+	renderEvent(c, snapshot)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(sseKeepAlivePeriod)
+	defer ticker.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				if h.raceService.ShuttingDown() {
+					c.Render(-1, sse.Event{
+						Event: models.RaceEventReconnect,
+						Retry: uint(sseReconnectDelay.Milliseconds()),
+						Data:  gin.H{"type": models.RaceEventReconnect},
+					})
+				}
+				return false
+			}
+			if event.ID != 0 && event.ID <= lastEventID {
+				// Already sent while catching up.
+				return true
+			}
+			renderEvent(c, event)
+			return true
+		case <-ticker.C:
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		}
+	})
+}
+
+func renderEvent(c *gin.Context, event models.RaceEvent) {
+	e := sse.Event{
+		Event: event.Type,
+		Data:  event,
+	}
+	if event.ID != 0 {
+		e.Id = strconv.Itoa(event.ID)
+	}
+	c.Render(-1, e)
+}
+
+func (h *RacesHandler) raceSnapshot(ctx context.Context, raceUUID string) (models.RaceEvent, error) {
+	race, err := h.raceService.GetRaceByUUID(ctx, raceUUID)
+	if err != nil {
+		return models.RaceEvent{}, err
+	}
+
+	participants, err := h.raceService.GetRaceParticipants(ctx, race.ID)
+	if err != nil {
+		return models.RaceEvent{}, err
+	}
+
+	return models.RaceEvent{
+		Type:   models.RaceEventSnapshot,
+		RaceID: race.ID,
+		Data: gin.H{
+			"race":         race,
+			"participants": participants,
+		},
+		Timestamp: time.Now(),
+	}, nil
+}
+
+// reply queues an error for the client, dropping it if the writer is behind
+// so a stalled connection never blocks the reader.
+func reply(replies chan<- raceStreamError, message string) {
+	select {
+	case replies <- raceStreamError{Type: "error", Error: message}:
+	default:
+	}
+}
+
+func closeStream(conn *websocket.Conn, code int, text string) {
+	conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, text),
+		time.Now().Add(streamWriteWait),
+	)
+}
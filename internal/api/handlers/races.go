@@ -1,21 +1,28 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
-	"strconv"
-
+	"ergracer-api/internal/models"
 	"ergracer-api/internal/services"
 
 	"github.com/gin-gonic/gin"
 )
 
 type RacesHandler struct {
-	raceService *services.RaceService
+	raceService       *services.RaceService
+	raceScheduler     *services.RaceScheduler
+	friendshipService *services.FriendshipService
+	emailService      *services.EmailService
 }
 
-func NewRacesHandler(raceService *services.RaceService) *RacesHandler {
+func NewRacesHandler(raceService *services.RaceService, raceScheduler *services.RaceScheduler, friendshipService *services.FriendshipService, emailService *services.EmailService) *RacesHandler {
 	return &RacesHandler{
-		raceService: raceService,
+		raceService:       raceService,
+		raceScheduler:     raceScheduler,
+		friendshipService: friendshipService,
+		emailService:      emailService,
 	}
 }
 
@@ -23,8 +30,15 @@ type CreateRaceRequest struct {
 	Distance int `json:"distance" binding:"required,min=100"`
 }
 
+// JoinRaceRequest names the race to join by its UUID or its join code.
 type JoinRaceRequest struct {
+	RaceUUID string `json:"race_uuid" binding:"required_without=JoinCode"`
+	JoinCode string `json:"join_code" binding:"required_without=RaceUUID"`
+}
+
+type InviteToRaceRequest struct {
 	RaceUUID string `json:"race_uuid" binding:"required"`
+	FriendID int    `json:"friend_id" binding:"required"`
 }
 
 type SetReadyRequest struct {
@@ -48,7 +62,7 @@ func (h *RacesHandler) CreateRace(c *gin.Context) {
 		return
 	}
 
-	race, err := h.raceService.CreateRace(userID.(int), req.Distance)
+	race, err := h.raceService.CreateRace(c.Request.Context(), userID.(int), req.Distance)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create race"})
 		return
@@ -70,25 +84,75 @@ func (h *RacesHandler) JoinRace(c *gin.Context) {
 		return
 	}
 
-	err := h.raceService.JoinRace(req.RaceUUID, userID.(int))
-	if err != nil {
+	ref := services.RaceRef{UUID: req.RaceUUID, JoinCode: req.JoinCode}
+	race, err := h.raceService.JoinRace(c.Request.Context(), ref, userID.(int))
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrRaceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Race not found"})
+		return
+	case errors.Is(err, models.ErrIllegalTransition):
+		c.JSON(http.StatusConflict, gin.H{"error": "Race is no longer accepting participants"})
+		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to join race"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Joined race successfully", "race": race})
+}
+
+func (h *RacesHandler) InviteToRace(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req InviteToRaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Joined race successfully"})
+	race, err := h.raceService.GetRaceByUUID(c.Request.Context(), req.RaceUUID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Race not found"})
+		return
+	}
+
+	if race.Status != models.RaceWaiting {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Race is no longer accepting participants"})
+		return
+	}
+
+	areFriends, err := h.friendshipService.AreFriends(c.Request.Context(), userID.(int), req.FriendID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check friendship"})
+		return
+	}
+	if !areFriends {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only invite friends"})
+		return
+	}
+
+	h.emailService.SendInBackground(c.Request.Context(), "race invite email", func(ctx context.Context) error {
+		return h.emailService.SendRaceInvite(ctx, userID.(int), req.FriendID, race)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Race invitation sent"})
 }
 
 func (h *RacesHandler) GetRace(c *gin.Context) {
 	raceUUID := c.Param("uuid")
 	
-	race, err := h.raceService.GetRaceByUUID(raceUUID)
+	race, err := h.raceService.GetRaceByUUID(c.Request.Context(), raceUUID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Race not found"})
 		return
 	}
 
-	participants, err := h.raceService.GetRaceParticipants(race.ID)
+	participants, err := h.raceService.GetRaceParticipants(c.Request.Context(), race.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get participants"})
 		return
@@ -100,6 +164,27 @@ func (h *RacesHandler) GetRace(c *gin.Context) {
 	})
 }
 
+// GetRaceTimeline returns the ordered log of who joined, readied and finished
+// a race and when it changed status, for reviewing a disputed race.
+func (h *RacesHandler) GetRaceTimeline(c *gin.Context) {
+	race, err := h.raceService.GetRaceByUUID(c.Request.Context(), c.Param("uuid"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Race not found"})
+		return
+	}
+
+	events, err := h.raceService.GetRaceTimeline(c.Request.Context(), race.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get race timeline"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"race_uuid": race.UUID,
+		"events":    events,
+	})
+}
+
 func (h *RacesHandler) SetReady(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -107,12 +192,7 @@ func (h *RacesHandler) SetReady(c *gin.Context) {
 		return
 	}
 
-	raceIDStr := c.Param("raceId")
-	raceID, err := strconv.Atoi(raceIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid race ID"})
-		return
-	}
+	race := c.MustGet("race").(*models.Race)
 
 	var req SetReadyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -120,18 +200,22 @@ func (h *RacesHandler) SetReady(c *gin.Context) {
 		return
 	}
 
-	err = h.raceService.SetReadyStatus(raceID, userID.(int), req.Ready)
+	err := h.raceService.SetReadyStatus(c.Request.Context(), race.ID, userID.(int), req.Ready)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to set ready status"})
+		respondRaceError(c, err, "Failed to set ready status")
 		return
 	}
 
 	if req.Ready {
-		err = h.raceService.CheckAndStartCountdown(raceID)
+		startAt, err := h.raceService.CheckAndStartCountdown(c.Request.Context(), race.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check countdown"})
 			return
 		}
+
+		if startAt != nil {
+			h.raceScheduler.Schedule(race.ID, *startAt)
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Ready status updated"})
@@ -144,12 +228,7 @@ func (h *RacesHandler) UpdateProgress(c *gin.Context) {
 		return
 	}
 
-	raceIDStr := c.Param("raceId")
-	raceID, err := strconv.Atoi(raceIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid race ID"})
-		return
-	}
+	race := c.MustGet("race").(*models.Race)
 
 	var req UpdateProgressRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -157,9 +236,9 @@ func (h *RacesHandler) UpdateProgress(c *gin.Context) {
 		return
 	}
 
-	err = h.raceService.UpdateRaceProgress(raceID, userID.(int), req.Distance)
+	err := h.raceService.UpdateRaceProgress(c.Request.Context(), race.ID, userID.(int), req.Distance)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update progress"})
+		respondRaceError(c, err, "Failed to update progress")
 		return
 	}
 
@@ -167,18 +246,23 @@ func (h *RacesHandler) UpdateProgress(c *gin.Context) {
 }
 
 func (h *RacesHandler) StartRace(c *gin.Context) {
-	raceIDStr := c.Param("raceId")
-	raceID, err := strconv.Atoi(raceIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid race ID"})
-		return
-	}
+	race := c.MustGet("race").(*models.Race)
 
-	err = h.raceService.StartRace(raceID)
+	err := h.raceService.StartRace(c.Request.Context(), race.ID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to start race"})
+		respondRaceError(c, err, "Failed to start race")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Race started"})
+}
+
+// respondRaceError answers a failed race status change with 409 Conflict if
+// the race or participant is not in a state that allows it.
+func respondRaceError(c *gin.Context, err error, message string) {
+	if errors.Is(err, models.ErrIllegalTransition) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": message})
 }
\ No newline at end of file
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"ergracer-api/internal/services"
+)
+
+// registerJobs adds the housekeeping jobs to the job runner.
+func (s *Server) registerJobs(userService *services.UserService, sessionService *services.SessionService, raceService *services.RaceService) {
+	jobs := s.config.Jobs
+
+	s.jobRunner.Register(services.Job{
+		Name:     "expired_sessions",
+		Interval: time.Hour,
+		Run: func(ctx context.Context) (int64, error) {
+			return sessionService.DeleteExpiredSessions(ctx)
+		},
+	})
+
+	s.jobRunner.Register(services.Job{
+		Name:     "idle_races",
+		Interval: 5 * time.Minute,
+		Run: func(ctx context.Context) (int64, error) {
+			return raceService.AbandonIdleRaces(ctx, jobs.IdleRaceTimeout)
+		},
+	})
+
+	s.jobRunner.Register(services.Job{
+		Name:     "unverified_accounts",
+		Interval: time.Hour,
+		Run: func(ctx context.Context) (int64, error) {
+			return userService.DeleteUnverifiedUsers(ctx, jobs.UnverifiedAccountTTL)
+		},
+	})
+
+	s.jobRunner.Register(services.Job{
+		Name:     "race_updates",
+		Interval: 24 * time.Hour,
+		Run: func(ctx context.Context) (int64, error) {
+			return raceService.DeleteRaceUpdates(ctx, jobs.RaceUpdateRetention)
+		},
+	})
+}
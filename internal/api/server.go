@@ -1,28 +1,52 @@
 package api
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
 
 	"ergracer-api/internal/api/handlers"
 	"ergracer-api/internal/config"
+	"ergracer-api/internal/mailer"
+	"ergracer-api/internal/metrics"
 	"ergracer-api/internal/middleware"
 	"ergracer-api/internal/services"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 type Server struct {
-	router *gin.Engine
-	db     *sql.DB
-	config *config.Config
+	router        *gin.Engine
+	db            *sql.DB
+	config        *config.Config
+	mailer        mailer.Mailer
+	emailService  *services.EmailService
+	raceBroker    *services.RaceBroker
+	raceScheduler *services.RaceScheduler
+	jobRunner     *services.JobRunner
+	logger        *slog.Logger
+	draining      atomic.Bool
 }
 
-func NewServer(db *sql.DB, config *config.Config) *Server {
+func NewServer(db *sql.DB, config *config.Config, mailer mailer.Mailer, logger *slog.Logger) (*Server, error) {
 	router := gin.New()
 
-	// Set trusted proxies for security
-	router.SetTrustedProxies([]string{"127.0.0.1", "::1"})
+	// Only trust X-Forwarded-For from known proxies when resolving client IPs
+	if err := router.SetTrustedProxies(config.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
 
+	router.Use(otelgin.Middleware(config.Tracing.ServiceName, otelgin.WithFilter(tracedRequest)))
+	router.Use(middleware.RequestID(logger))
+	if config.Metrics.Enabled {
+		router.Use(metrics.Middleware())
+	}
 	router.Use(middleware.Logger())
 	router.Use(middleware.Recovery())
 	router.Use(middleware.CORS())
@@ -31,37 +55,85 @@ func NewServer(db *sql.DB, config *config.Config) *Server {
 		router: router,
 		db:     db,
 		config: config,
+		mailer: mailer,
+		logger: logger,
+	}
+
+	if config.Metrics.Enabled {
+		if err := metrics.RegisterDB(db); err != nil {
+			return nil, fmt.Errorf("failed to register database metrics: %w", err)
+		}
 	}
 
 	server.setupRoutes()
-	return server
+	return server, nil
+}
+
+// tracedRequest leaves probes and metric scrapes out of traces.
+func tracedRequest(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/metrics", "/healthz", "/readyz", "/health":
+		return false
+	}
+	return true
 }
 
 func (s *Server) setupRoutes() {
 	userService := services.NewUserService(s.db)
-	sessionService := services.NewSessionService(s.db)
+	sessionService := services.NewSessionService(s.db, s.config.RefreshTokenSecret(), s.config.JWT.RevokeAllOnReuse)
 	friendshipService := services.NewFriendshipService(s.db)
-	raceService := services.NewRaceService(s.db)
+	templates := mailer.NewTemplates(s.config.Mail.TemplateDir)
+	emailService := services.NewEmailService(s.db, s.mailer, templates, s.config.AppURL(), s.logger)
+	s.emailService = emailService
+	s.raceBroker = services.NewRaceBroker()
+	raceService := services.NewRaceService(s.db, s.raceBroker, emailService, s.logger)
+	s.raceScheduler = services.NewRaceScheduler(raceService, s.logger)
+	s.jobRunner = services.NewJobRunner(s.db, s.logger)
+	s.registerJobs(userService, sessionService, raceService)
 
-	authHandler := handlers.NewAuthHandler(userService, sessionService, s.config)
-	friendsHandler := handlers.NewFriendsHandler(friendshipService, userService)
-	racesHandler := handlers.NewRacesHandler(raceService)
+	authHandler := handlers.NewAuthHandler(userService, sessionService, emailService, s.config)
+	sessionsHandler := handlers.NewSessionsHandler(sessionService)
+	friendsHandler := handlers.NewFriendsHandler(friendshipService, userService, emailService)
+	racesHandler := handlers.NewRacesHandler(raceService, s.raceScheduler, friendshipService, emailService)
 	historyHandler := handlers.NewHistoryHandler(s.db)
+	adminHandler := handlers.NewAdminHandler(s.jobRunner)
+	healthHandler := handlers.NewHealthHandler(s.db, s.config.MailDriver(), s.draining.Load)
 
 	api := s.router.Group("/api/v1")
 
+	// Endpoints that send email to an address given by an anonymous caller.
+	// Each has its own limiter so that using up one does not lock a client
+	// out of the other.
+	resendLimit := middleware.RateLimit(5, 15*time.Minute)
+	forgotLimit := middleware.RateLimit(5, 15*time.Minute)
+
 	auth := api.Group("/auth")
 	{
 		auth.POST("/register", authHandler.Register)
 		auth.POST("/login", authHandler.Login)
 		auth.POST("/refresh", authHandler.RefreshToken)
 		auth.GET("/verify-email", authHandler.VerifyEmail)
+		auth.POST("/resend-verification", resendLimit, authHandler.ResendVerification)
+		auth.POST("/forgot-password", forgotLimit, authHandler.ForgotPassword)
+		auth.POST("/reset-password", authHandler.ResetPassword)
 	}
 
 	protected := api.Group("/")
-	protected.Use(middleware.AuthRequired(s.config.JWTSecret()))
+	protected.Use(middleware.AuthRequired(s.config.JWTSecret(), sessionService.SessionActive))
 	{
 		protected.GET("/profile", authHandler.GetProfile)
+		protected.PUT("/profile/password", authHandler.ChangePassword)
+		protected.PUT("/profile/email", authHandler.ChangeEmail)
+		protected.PUT("/profile/username", authHandler.ChangeUsername)
+		protected.PUT("/profile/locale", authHandler.SetLocale)
+
+		sessions := protected.Group("/auth")
+		{
+			sessions.GET("/sessions", sessionsHandler.GetSessions)
+			sessions.DELETE("/sessions/:sessionId", sessionsHandler.RevokeSession)
+			sessions.POST("/logout", sessionsHandler.Logout)
+			sessions.POST("/logout-all", sessionsHandler.LogoutAll)
+		}
 
 		friends := protected.Group("/friends")
 		{
@@ -72,23 +144,148 @@ func (s *Server) setupRoutes() {
 		}
 
 		races := protected.Group("/races")
+		races.Use(middleware.RaceLogContext())
+		participantOnly := middleware.RaceAccessRequired(raceService.AuthorizeRace, services.RaceActionParticipate)
+		creatorOnly := middleware.RaceAccessRequired(raceService.AuthorizeRace, services.RaceActionManage)
 		{
 			races.POST("/", racesHandler.CreateRace)
 			races.POST("/join", racesHandler.JoinRace)
+			races.POST("/invite", racesHandler.InviteToRace)
 			races.GET("/:uuid", racesHandler.GetRace)
-			races.POST("/:raceId/ready", racesHandler.SetReady)
-			races.POST("/:raceId/progress", racesHandler.UpdateProgress)
-			races.POST("/:raceId/start", racesHandler.StartRace)
+			races.GET("/:uuid/live", racesHandler.StreamRace)
+			races.GET("/:uuid/events", racesHandler.RaceEvents)
+			races.GET("/:uuid/timeline", racesHandler.GetRaceTimeline)
+			races.POST("/:uuid/ready", participantOnly, racesHandler.SetReady)
+			races.POST("/:uuid/progress", participantOnly, racesHandler.UpdateProgress)
+			races.POST("/:uuid/start", creatorOnly, racesHandler.StartRace)
 		}
 
 		protected.GET("/history", historyHandler.GetUserRaceHistory)
+
+		admin := protected.Group("/admin")
+		admin.Use(middleware.AdminRequired(userService.IsAdmin))
+		{
+			admin.GET("/jobs", adminHandler.GetJobs)
+			admin.GET("/jobs/:name/runs", adminHandler.GetJobRuns)
+		}
+	}
+
+	// Rendered with sample data only; meant for development environments.
+	if s.config.Mail.Preview {
+		emailPreviewHandler := handlers.NewEmailPreviewHandler(templates)
+		s.router.GET("/dev/emails/:template", emailPreviewHandler.PreviewEmail)
+	}
+
+	if s.config.Metrics.Enabled {
+		s.router.GET("/metrics", metrics.Handler())
+	}
+
+	s.router.GET("/healthz", healthHandler.Liveness)
+	s.router.HEAD("/healthz", healthHandler.Liveness)
+	s.router.GET("/readyz", healthHandler.Readiness)
+	s.router.HEAD("/readyz", healthHandler.Readiness)
+
+	// Deprecated: use /healthz.
+	s.router.GET("/health", healthHandler.Liveness)
+	s.router.HEAD("/health", healthHandler.Liveness)
+}
+
+// Run serves HTTP until ctx is cancelled and then shuts down gracefully: the
+// listener is closed, live race streams are told to reconnect elsewhere, and
+// in-flight requests and the emails they queued get until
+// server.shutdown_timeout to finish before their connections are closed. The
+// caller closes the database afterwards.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.raceScheduler.Start(); err != nil {
+		return err
+	}
+	defer s.raceScheduler.Stop()
+
+	if s.config.Jobs.Enabled {
+		s.jobRunner.Start()
+		defer s.jobRunner.Stop()
+	}
+
+	// WebSocket and event stream handlers lift the write timeout for their
+	// own connections.
+	httpServer := &http.Server{
+		Addr:              s.config.ListenAddr(),
+		Handler:           s.router,
+		ReadTimeout:       s.config.Server.ReadTimeout,
+		ReadHeaderTimeout: s.config.Server.ReadHeaderTimeout,
+		WriteTimeout:      s.config.Server.WriteTimeout,
+		IdleTimeout:       s.config.Server.IdleTimeout,
+		MaxHeaderBytes:    s.config.Server.MaxHeaderBytes,
+	}
+
+	// Streams are not tracked by Shutdown once hijacked or while they are
+	// still writing, so they are ended separately.
+	httpServer.RegisterOnShutdown(s.raceBroker.Close)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.config.TLSEnabled() {
+			serveErr <- httpServer.ListenAndServeTLS(s.config.Server.TLSCertFile, s.config.Server.TLSKeyFile)
+		} else {
+			serveErr <- httpServer.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
 	}
 
-	s.router.HEAD("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "ok"})
-	})
+	// Fail readiness first and keep serving for a while, so load balancers
+	// stop sending new requests before the listener closes.
+	s.draining.Store(true)
+	if delay := s.config.Server.DrainDelay; delay > 0 {
+		s.logger.Info("draining, readiness now fails", "delay", delay)
+		time.Sleep(delay)
+	}
+
+	s.logger.Info("shutting down, waiting for requests to finish", "timeout", s.config.Server.ShutdownTimeout)
+	s.raceScheduler.Stop()
+	s.jobRunner.Stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.Server.ShutdownTimeout)
+	defer cancel()
+
+	err := httpServer.Shutdown(shutdownCtx)
+	if err == nil {
+		err = waitUntil(shutdownCtx, s.raceBroker.Wait)
+	}
+	// Race results and invites are sent after the response, and need the
+	// database to load their recipients.
+	if err == nil {
+		err = waitUntil(shutdownCtx, s.emailService.Wait)
+	}
+	if err != nil {
+		httpServer.Close()
+		return fmt.Errorf("graceful shutdown did not finish: %w", err)
+	}
+
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	s.logger.Info("server stopped")
+	return nil
 }
 
-func (s *Server) Start(addr string) error {
-	return s.router.Run(addr)
+// waitUntil calls wait, which blocks until work such as live race streams
+// or background emails has finished, and gives up when ctx is done.
+func waitUntil(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,54 @@
+// Package buildinfo describes the running binary.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version and Commit can be set at build time, e.g.
+//
+//	go build -ldflags "-X ergracer-api/internal/buildinfo.Version=v1.2.0 -X ergracer-api/internal/buildinfo.Commit=$(git rev-parse HEAD)"
+//
+// When Commit is not set it is taken from the VCS information Go embeds in
+// binaries built inside a git checkout.
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// Info identifies a build.
+type Info struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	Modified   bool   `json:"modified,omitempty"`
+	GoVersion  string `json:"go_version"`
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			info.CommitTime = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
@@ -0,0 +1,141 @@
+// Package cli implements the administrative subcommands of the ergracer-api
+// binary, so routine operations do not require a psql session.
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"ergracer-api/internal/config"
+	"ergracer-api/internal/database"
+	"ergracer-api/internal/models"
+	"ergracer-api/internal/services"
+)
+
+// ErrUsage is returned when a command is called with the wrong arguments. The
+// usage text has already been printed.
+var ErrUsage = errors.New("invalid usage")
+
+const usage = `Usage: ergracer-api [command]
+
+Without a command the API server is started.
+
+Commands:
+  serve                          Start the API server
+  migrate up                     Apply pending migrations
+  migrate down [-steps N]        Roll back the last N migrations (default 1)
+  migrate status                 List migrations and whether they are applied
+  user create -email E -username U [-password P] [-verified] [-locale L]
+                                 Create a user
+  user verify <user>             Mark a user's email as verified
+  user disable <user>            Disable a user and revoke their sessions
+  user enable <user>             Re-enable a disabled user
+  user promote <user>            Grant access to the admin API
+  user demote <user>             Revoke access to the admin API
+  sessions purge-expired         Delete expired sessions
+  race inspect <uuid>            Show a race and its participants
+  race force-finish <uuid>       Finish a race that cannot finish on its own
+  seed                           Create demo users, friendships and races
+
+<user> is a user ID, email address or username.
+`
+
+// command runs a subcommand with the arguments that follow its name.
+type command func(cfg *config.Config, db *sql.DB, args []string) error
+
+var commands = map[string]map[string]command{
+	"migrate": {
+		"up":     migrateUp,
+		"down":   migrateDown,
+		"status": migrateStatus,
+	},
+	"user": {
+		"create":  userCreate,
+		"verify":  userVerify,
+		"disable": userDisable,
+		"enable":  userEnable,
+		"promote": userPromote,
+		"demote":  userDemote,
+	},
+	"sessions": {
+		"purge-expired": sessionsPurgeExpired,
+	},
+	"race": {
+		"inspect":      raceInspect,
+		"force-finish": raceForceFinish,
+	},
+}
+
+// Run executes the command named by args, e.g. ["migrate", "status"].
+func Run(cfg *config.Config, args []string) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(usage)
+		return nil
+	}
+
+	var run command
+	var rest []string
+	if args[0] == "seed" {
+		run, rest = seed, args[1:]
+	} else if group, ok := commands[args[0]]; ok && len(args) > 1 && group[args[1]] != nil {
+		run, rest = group[args[1]], args[2:]
+	} else {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", strings.Join(args, " "), usage)
+		return ErrUsage
+	}
+
+	db, err := database.Connect(cfg.DatabaseURL())
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	return run(cfg, db, rest)
+}
+
+// newFlagSet returns a flag set that reports errors as ErrUsage.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return ErrUsage
+	}
+	return nil
+}
+
+// singleArg returns the only positional argument of a command.
+func singleArg(name string, args []string) (string, error) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: ergracer-api %s\n", name)
+		return "", ErrUsage
+	}
+	return args[0], nil
+}
+
+// findUser looks a user up by ID, email address or username.
+func findUser(userService *services.UserService, identifier string) (*models.User, error) {
+	var user *models.User
+	var err error
+	if id, convErr := strconv.Atoi(identifier); convErr == nil {
+		user, err = userService.GetUserByID(context.Background(), id)
+	} else if strings.Contains(identifier, "@") {
+		user, err = userService.GetUserByEmail(context.Background(), identifier)
+	} else {
+		user, err = userService.GetUserByUsername(context.Background(), identifier)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("user %q not found", identifier)
+	}
+	return user, err
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ergracer-api/internal/config"
+	"ergracer-api/internal/database"
+)
+
+func migrateUp(cfg *config.Config, db *sql.DB, args []string) error {
+	if err := parseFlags(newFlagSet("migrate up"), args); err != nil {
+		return err
+	}
+
+	if err := database.Migrate(db); err != nil {
+		return err
+	}
+	return printSchemaVersion(db)
+}
+
+func migrateDown(cfg *config.Config, db *sql.DB, args []string) error {
+	fs := newFlagSet("migrate down")
+	steps := fs.Int("steps", 1, "number of migrations to roll back")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := database.MigrateDown(db, *steps); err != nil {
+		return err
+	}
+	return printSchemaVersion(db)
+}
+
+func migrateStatus(cfg *config.Config, db *sql.DB, args []string) error {
+	if err := parseFlags(newFlagSet("migrate status"), args); err != nil {
+		return err
+	}
+
+	states, err := database.MigrationStatus(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, state := range states {
+		name := state.Name
+		if name == "" {
+			name = "(unknown to this build)"
+		}
+		appliedAt := "pending"
+		if state.AppliedAt != nil {
+			appliedAt = state.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", state.Version, name, appliedAt)
+	}
+	return w.Flush()
+}
+
+func printSchemaVersion(db *sql.DB) error {
+	version, err := database.SchemaVersion(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema is at version %d\n", version)
+	return nil
+}
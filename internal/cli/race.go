@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ergracer-api/internal/config"
+	"ergracer-api/internal/models"
+	"ergracer-api/internal/services"
+)
+
+func raceInspect(cfg *config.Config, db *sql.DB, args []string) error {
+	raceUUID, err := singleArg("race inspect <uuid>", args)
+	if err != nil {
+		return err
+	}
+
+	raceService := newRaceService(db)
+	race, err := findRace(raceService, raceUUID)
+	if err != nil {
+		return err
+	}
+
+	participants, err := raceService.GetRaceParticipants(context.Background(), race.ID)
+	if err != nil {
+		return err
+	}
+
+	usernames, err := usernamesByID(db, race.ID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Race %d (%s)\n", race.ID, race.UUID)
+	if race.JoinCode != nil {
+		fmt.Printf("  Join code:  %s\n", *race.JoinCode)
+	}
+	fmt.Printf("  Distance:   %d m\n", race.Distance)
+	fmt.Printf("  Status:     %s\n", race.Status)
+	fmt.Printf("  Created by: %d at %s\n", race.CreatedBy, race.CreatedAt.Format(time.RFC3339))
+	fmt.Printf("  Countdown:  %s\n", formatTime(race.CountdownAt))
+	fmt.Printf("  Started:    %s\n", formatTime(race.StartedAt))
+	fmt.Printf("  Finished:   %s\n", formatTime(race.FinishedAt))
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tUSERNAME\tSTATUS\tDISTANCE\tPOSITION\tPACE\tFINISHED AT")
+	for _, p := range participants {
+		position := "-"
+		if p.Position != nil {
+			position = fmt.Sprint(*p.Position)
+		}
+		pace := "-"
+		if p.Pace != nil {
+			pace = *p.Pace
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			p.UserID, usernames[p.UserID], p.Status, p.CurrentDistance, position, pace, formatTime(p.FinishedAt))
+	}
+	return w.Flush()
+}
+
+func raceForceFinish(cfg *config.Config, db *sql.DB, args []string) error {
+	raceUUID, err := singleArg("race force-finish <uuid>", args)
+	if err != nil {
+		return err
+	}
+
+	raceService := newRaceService(db)
+	race, err := findRace(raceService, raceUUID)
+	if err != nil {
+		return err
+	}
+
+	if err := raceService.ForceFinishRace(context.Background(), race.ID); err != nil {
+		if errors.Is(err, services.ErrRaceAlreadyFinished) {
+			return fmt.Errorf("race %s is already finished", race.UUID)
+		}
+		return err
+	}
+
+	fmt.Printf("Finished race %d (%s), which was %s\n", race.ID, race.UUID, race.Status)
+	return nil
+}
+
+// newRaceService returns a race service for one-off commands. Events it
+// publishes reach no one, since live subscribers are connected to the API
+// server process.
+func newRaceService(db *sql.DB) *services.RaceService {
+	return services.NewRaceService(db, services.NewRaceBroker(), nil, slog.Default())
+}
+
+func findRace(raceService *services.RaceService, raceUUID string) (*models.Race, error) {
+	race, err := raceService.GetRaceByUUID(context.Background(), raceUUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("race %q not found", raceUUID)
+	}
+	return race, err
+}
+
+func usernamesByID(db *sql.DB, raceID int) (map[int]string, error) {
+	rows, err := db.Query(`
+		SELECT u.id, u.username
+		FROM race_participants rp
+		JOIN users u ON u.id = rp.user_id
+		WHERE rp.race_id = $1`, raceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usernames := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var username string
+		if err := rows.Scan(&id, &username); err != nil {
+			return nil, err
+		}
+		usernames[id] = username
+	}
+	return usernames, rows.Err()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
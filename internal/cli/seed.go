@@ -0,0 +1,215 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"ergracer-api/internal/config"
+	"ergracer-api/internal/models"
+	"ergracer-api/internal/services"
+
+	"github.com/google/uuid"
+)
+
+// seedPassword is the password of every demo user.
+const seedPassword = "ergracer-demo"
+
+var seedUsers = []struct {
+	username string
+	locale   string
+}{
+	{"alice", "en"},
+	{"bob", "en"},
+	{"carol", "es"},
+}
+
+// seed creates demo users who raced each other once, two of whom are friends,
+// plus a race waiting for participants. It does nothing if the demo users
+// already exist.
+func seed(cfg *config.Config, db *sql.DB, args []string) error {
+	if err := parseFlags(newFlagSet("seed"), args); err != nil {
+		return err
+	}
+
+	userService := services.NewUserService(db)
+	if _, err := userService.GetUserByUsername(context.Background(), seedUsers[0].username); err == nil {
+		fmt.Println("Demo data already present")
+		return nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	userIDs := make([]int, len(seedUsers))
+	for i, u := range seedUsers {
+		user, err := userService.CreateUser(context.Background(), u.username+"@example.com", u.username, seedPassword, u.locale)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", u.username, err)
+		}
+		if err := userService.MarkEmailVerified(context.Background(), user.ID); err != nil {
+			return err
+		}
+		userIDs[i] = user.ID
+	}
+	alice, bob, carol := userIDs[0], userIDs[1], userIDs[2]
+
+	finishedUUID, err := seedFinishedRace(db, 2000, alice, []int{alice, bob, carol})
+	if err != nil {
+		return fmt.Errorf("failed to create finished race: %w", err)
+	}
+
+	friendshipService := services.NewFriendshipService(db)
+	if _, err := friendshipService.InviteFriend(context.Background(), alice, bob); err != nil {
+		return err
+	}
+	if err := friendshipService.AcceptFriendship(context.Background(), bob, alice); err != nil {
+		return err
+	}
+	if _, err := friendshipService.InviteFriend(context.Background(), carol, alice); err != nil {
+		return err
+	}
+
+	raceService := newRaceService(db)
+	waiting, err := raceService.CreateRace(context.Background(), bob, 1000)
+	if err != nil {
+		return fmt.Errorf("failed to create waiting race: %w", err)
+	}
+
+	fmt.Printf("Created users alice, bob and carol (@example.com) with password %q\n", seedPassword)
+	fmt.Println("alice and bob are friends; carol has a pending request to alice")
+	fmt.Printf("Finished race: %s\n", finishedUUID)
+	fmt.Printf("Waiting race:  %s\n", waiting.UUID)
+	return nil
+}
+
+// seedFinishedRace inserts a race that finished an hour ago, with the given
+// participants finishing in order a few seconds apart. Its timeline is written
+// along with it, walking the race state machine as a real race would.
+func seedFinishedRace(db *sql.DB, distance, createdBy int, participantIDs []int) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	raceUUID := uuid.New().String()
+	startedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	createdAt := startedAt.Add(-3 * time.Minute)
+	winningTime := 7*time.Minute + 12*time.Second
+	finishedAt := startedAt.Add(winningTime + time.Duration(len(participantIDs)-1)*4*time.Second)
+
+	var raceID int
+	err = tx.QueryRow(`
+		INSERT INTO races (uuid, distance, status, created_by, created_at, countdown_at, started_at, finished_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6, $7)
+		RETURNING id`,
+		raceUUID, distance, models.RaceFinished, createdBy, createdAt, startedAt, finishedAt,
+	).Scan(&raceID)
+	if err != nil {
+		return "", err
+	}
+
+	timeline := &seedTimeline{tx: tx, raceID: raceID, status: models.RaceWaiting, participants: map[int]models.ParticipantStatus{}}
+	timeline.record(createdAt, 0, models.TimelineRaceCreated, "race", "", string(models.RaceWaiting),
+		map[string]interface{}{"distance": distance})
+
+	for i, userID := range participantIDs {
+		joinedAt := createdAt.Add(time.Duration(i) * 20 * time.Second)
+		timeline.participant(joinedAt, userID, models.ParticipantNotReady, models.TimelineJoined, nil)
+
+		elapsed := winningTime + time.Duration(i)*4*time.Second
+		pace := elapsed * 500 / time.Duration(distance)
+		_, err = tx.Exec(`
+			INSERT INTO race_participants (race_id, user_id, status, current_distance, finished_at, pace, position, joined_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			raceID, userID, models.ParticipantFinished, distance, startedAt.Add(elapsed),
+			fmt.Sprintf("%02d:%02d", int(pace.Minutes()), int(pace.Seconds())%60), i+1, joinedAt,
+		)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for i, userID := range participantIDs {
+		timeline.participant(startedAt.Add(-time.Minute+time.Duration(i)*10*time.Second), userID,
+			models.ParticipantReady, models.TimelineReadied, nil)
+	}
+
+	timeline.race(startedAt.Add(-10*time.Second), models.RaceCountdown, models.TimelineCountdownStarted,
+		map[string]interface{}{"countdown_at": startedAt, "participants": len(participantIDs)})
+	timeline.race(startedAt, models.RaceActive, models.TimelineRaceStarted, nil)
+	for _, userID := range participantIDs {
+		timeline.participant(startedAt, userID, models.ParticipantRacing, models.TimelineStartedRacing, nil)
+	}
+
+	for i, userID := range participantIDs {
+		timeline.participant(startedAt.Add(winningTime+time.Duration(i)*4*time.Second), userID,
+			models.ParticipantFinished, models.TimelineFinished, map[string]interface{}{"distance": distance})
+	}
+	timeline.race(finishedAt, models.RaceFinished, models.TimelineRaceFinished, nil)
+
+	if timeline.err != nil {
+		return "", timeline.err
+	}
+
+	return raceUUID, tx.Commit()
+}
+
+// seedTimeline writes the race_events of a seeded race, checking each step
+// against the race state machine. The first error is kept in err and later
+// steps are skipped.
+type seedTimeline struct {
+	tx           *sql.Tx
+	raceID       int
+	status       models.RaceStatus
+	participants map[int]models.ParticipantStatus
+	err          error
+}
+
+func (t *seedTimeline) race(at time.Time, to models.RaceStatus, eventType string, data map[string]interface{}) {
+	if t.err != nil {
+		return
+	}
+	if t.err = t.status.TransitionTo(to); t.err != nil {
+		return
+	}
+	t.record(at, 0, eventType, "race", string(t.status), string(to), data)
+	t.status = to
+}
+
+func (t *seedTimeline) participant(at time.Time, userID int, to models.ParticipantStatus, eventType string, data map[string]interface{}) {
+	if t.err != nil {
+		return
+	}
+	from := t.participants[userID]
+	if t.err = from.TransitionTo(to, t.status); t.err != nil {
+		return
+	}
+	t.record(at, userID, eventType, "participant", string(from), string(to), data)
+	t.participants[userID] = to
+}
+
+func (t *seedTimeline) record(at time.Time, userID int, eventType, entity, from, to string, data map[string]interface{}) {
+	if t.err != nil {
+		return
+	}
+
+	var details interface{}
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			t.err = err
+			return
+		}
+		details = string(b)
+	}
+
+	_, t.err = t.tx.Exec(`
+		INSERT INTO race_events (race_id, user_id, type, entity, from_status, to_status, data, created_at)
+		VALUES ($1, NULLIF($2, 0), $3, $4, NULLIF($5, ''), $6, $7, $8)`,
+		t.raceID, userID, eventType, entity, from, to, details, at,
+	)
+}
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"ergracer-api/internal/config"
+	"ergracer-api/internal/services"
+)
+
+func sessionsPurgeExpired(cfg *config.Config, db *sql.DB, args []string) error {
+	if err := parseFlags(newFlagSet("sessions purge-expired"), args); err != nil {
+		return err
+	}
+
+	sessionService := services.NewSessionService(db, cfg.RefreshTokenSecret(), cfg.JWT.RevokeAllOnReuse)
+	deleted, err := sessionService.DeleteExpiredSessions(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Deleted %d expired sessions\n", deleted)
+	return nil
+}
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	"ergracer-api/internal/config"
+	"ergracer-api/internal/mailer"
+	"ergracer-api/internal/services"
+	"ergracer-api/internal/utils"
+)
+
+func userCreate(cfg *config.Config, db *sql.DB, args []string) error {
+	fs := newFlagSet("user create")
+	email := fs.String("email", "", "email address (required)")
+	username := fs.String("username", "", "username (required)")
+	password := fs.String("password", "", "password (generated and printed when empty)")
+	verified := fs.Bool("verified", false, "mark the email as verified")
+	locale := fs.String("locale", mailer.DefaultLocale, "locale for emails")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *email == "" || *username == "" {
+		fmt.Fprintln(os.Stderr, "Usage: ergracer-api user create -email E -username U [-password P] [-verified] [-locale L]")
+		return ErrUsage
+	}
+
+	generated := *password == ""
+	if generated {
+		token, err := utils.GenerateToken()
+		if err != nil {
+			return err
+		}
+		*password = token[:16]
+	}
+
+	userService := services.NewUserService(db)
+	user, err := userService.CreateUser(context.Background(), *email, *username, *password, mailer.NormalizeLocale(*locale))
+	if err != nil {
+		return err
+	}
+
+	if *verified {
+		if err := userService.MarkEmailVerified(context.Background(), user.ID); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Created user %d (%s, %s)\n", user.ID, user.Username, user.Email)
+	if generated {
+		fmt.Printf("Password: %s\n", *password)
+	}
+	return nil
+}
+
+func userVerify(cfg *config.Config, db *sql.DB, args []string) error {
+	identifier, err := singleArg("user verify <user>", args)
+	if err != nil {
+		return err
+	}
+
+	userService := services.NewUserService(db)
+	user, err := findUser(userService, identifier)
+	if err != nil {
+		return err
+	}
+
+	if err := userService.MarkEmailVerified(context.Background(), user.ID); err != nil {
+		return err
+	}
+
+	fmt.Printf("Verified %s for user %d (%s)\n", user.Email, user.ID, user.Username)
+	return nil
+}
+
+func userDisable(cfg *config.Config, db *sql.DB, args []string) error {
+	identifier, err := singleArg("user disable <user>", args)
+	if err != nil {
+		return err
+	}
+
+	userService := services.NewUserService(db)
+	user, err := findUser(userService, identifier)
+	if err != nil {
+		return err
+	}
+
+	if err := userService.SetDisabled(context.Background(), user.ID, true); err != nil {
+		return err
+	}
+
+	sessionService := services.NewSessionService(db, cfg.RefreshTokenSecret(), cfg.JWT.RevokeAllOnReuse)
+	if err := sessionService.DeleteUserSessions(context.Background(), user.ID); err != nil {
+		return fmt.Errorf("user disabled but revoking sessions failed: %w", err)
+	}
+
+	fmt.Printf("Disabled user %d (%s) and revoked their sessions\n", user.ID, user.Username)
+	return nil
+}
+
+func userEnable(cfg *config.Config, db *sql.DB, args []string) error {
+	identifier, err := singleArg("user enable <user>", args)
+	if err != nil {
+		return err
+	}
+
+	userService := services.NewUserService(db)
+	user, err := findUser(userService, identifier)
+	if err != nil {
+		return err
+	}
+
+	if err := userService.SetDisabled(context.Background(), user.ID, false); err != nil {
+		return err
+	}
+
+	fmt.Printf("Enabled user %d (%s)\n", user.ID, user.Username)
+	return nil
+}
+
+func userPromote(cfg *config.Config, db *sql.DB, args []string) error {
+	return setAdmin(db, "user promote <user>", args, true)
+}
+
+func userDemote(cfg *config.Config, db *sql.DB, args []string) error {
+	return setAdmin(db, "user demote <user>", args, false)
+}
+
+func setAdmin(db *sql.DB, name string, args []string, admin bool) error {
+	identifier, err := singleArg(name, args)
+	if err != nil {
+		return err
+	}
+
+	userService := services.NewUserService(db)
+	user, err := findUser(userService, identifier)
+	if err != nil {
+		return err
+	}
+
+	if err := userService.SetAdmin(context.Background(), user.ID, admin); err != nil {
+		return err
+	}
+
+	if admin {
+		fmt.Printf("User %d (%s) is now an admin\n", user.ID, user.Username)
+	} else {
+		fmt.Printf("User %d (%s) is no longer an admin\n", user.ID, user.Username)
+	}
+	return nil
+}
@@ -1,8 +1,16 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"net"
+	"net/mail"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,8 +18,14 @@ import (
 type Config struct {
 	Database DatabaseConfig `yaml:"database"`
 	JWT      JWTConfig      `yaml:"jwt"`
+	Mail     MailConfig     `yaml:"mail"`
 	Mailgun  MailgunConfig  `yaml:"mailgun"`
 	App      AppConfig      `yaml:"app"`
+	Server   ServerConfig   `yaml:"server"`
+	Jobs     JobsConfig     `yaml:"jobs"`
+	Log      LogConfig      `yaml:"log"`
+	Metrics  MetricsConfig  `yaml:"metrics"`
+	Tracing  TracingConfig  `yaml:"tracing"`
 }
 
 type DatabaseConfig struct {
@@ -19,7 +33,33 @@ type DatabaseConfig struct {
 }
 
 type JWTConfig struct {
-	Secret string `yaml:"secret"`
+	Secret           string `yaml:"secret"`
+	RefreshSecret    string `yaml:"refresh_secret"`
+	RevokeAllOnReuse bool   `yaml:"revoke_all_on_reuse"`
+}
+
+// Mail drivers selectable with mail.driver.
+const (
+	MailDriverMailgun = "mailgun"
+	MailDriverSMTP    = "smtp"
+	MailDriverLog     = "log"
+)
+
+type MailConfig struct {
+	Driver      string     `yaml:"driver"`
+	FromEmail   string     `yaml:"from_email"`
+	FromName    string     `yaml:"from_name"`
+	Dir         string     `yaml:"dir"`
+	TemplateDir string     `yaml:"template_dir"`
+	Preview     bool       `yaml:"preview"`
+	SMTP        SMTPConfig `yaml:"smtp"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
 }
 
 type MailgunConfig struct {
@@ -34,6 +74,53 @@ type AppConfig struct {
 	Port int    `yaml:"port"`
 }
 
+// LogConfig selects the log format ("json" or "text") and the minimum level
+// ("debug", "info", "warn" or "error").
+type LogConfig struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+}
+
+// MetricsConfig controls the Prometheus endpoint at /metrics.
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// TracingConfig selects where OpenTelemetry traces are sent. Exporter is
+// "none", "stdout" or "otlp"; the otlp exporter sends OTLP over HTTP to
+// Endpoint (host:port), or to the collector named by the standard
+// OTEL_EXPORTER_OTLP_* environment variables when Endpoint is empty.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+	ServiceName string  `yaml:"service_name"`
+}
+
+// JobsConfig controls the background housekeeping jobs.
+type JobsConfig struct {
+	Enabled              bool          `yaml:"enabled"`
+	IdleRaceTimeout      time.Duration `yaml:"idle_race_timeout"`
+	UnverifiedAccountTTL time.Duration `yaml:"unverified_account_ttl"`
+	RaceUpdateRetention  time.Duration `yaml:"race_update_retention"`
+}
+
+// ServerConfig tunes the HTTP server. The listen port is app.port.
+type ServerConfig struct {
+	Host              string        `yaml:"host"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	DrainDelay        time.Duration `yaml:"drain_delay"`
+	TrustedProxies    []string      `yaml:"trusted_proxies"`
+	TLSCertFile       string        `yaml:"tls_cert_file"`
+	TLSKeyFile        string        `yaml:"tls_key_file"`
+}
+
 // Legacy getters for backward compatibility
 func (c *Config) DatabaseURL() string {
 	return c.Database.URL
@@ -43,6 +130,15 @@ func (c *Config) JWTSecret() string {
 	return c.JWT.Secret
 }
 
+// RefreshTokenSecret is the key refresh tokens are hashed with. It falls back
+// to the JWT secret when no dedicated secret is configured.
+func (c *Config) RefreshTokenSecret() string {
+	if c.JWT.RefreshSecret != "" {
+		return c.JWT.RefreshSecret
+	}
+	return c.JWT.Secret
+}
+
 func (c *Config) MailgunDomain() string {
 	return c.Mailgun.Domain
 }
@@ -59,29 +155,266 @@ func (c *Config) MailgunFromName() string {
 	return c.Mailgun.FromName
 }
 
+// MailDriver returns the configured mail driver. Without one, Mailgun is used
+// if it is configured. Otherwise it is empty, which Validate rejects: email is
+// only logged when the log driver is chosen explicitly.
+func (c *Config) MailDriver() string {
+	if c.Mail.Driver != "" {
+		return c.Mail.Driver
+	}
+	if c.Mailgun.Domain != "" && c.Mailgun.APIKey != "" {
+		return MailDriverMailgun
+	}
+	return ""
+}
+
+// MailFromEmail returns the sender address, falling back to the one in the
+// mailgun section.
+func (c *Config) MailFromEmail() string {
+	if c.Mail.FromEmail != "" {
+		return c.Mail.FromEmail
+	}
+	return c.Mailgun.FromEmail
+}
+
+func (c *Config) MailFromName() string {
+	if c.Mail.FromName != "" {
+		return c.Mail.FromName
+	}
+	return c.Mailgun.FromName
+}
+
 func (c *Config) AppURL() string {
 	return c.App.URL
 }
 
-func Load() *Config {
-	configPath := getConfigPath()
-	
+// ListenAddr is the address the HTTP server listens on.
+func (c *Config) ListenAddr() string {
+	return net.JoinHostPort(c.Server.Host, strconv.Itoa(c.App.Port))
+}
+
+// TLSEnabled reports whether the server should serve HTTPS.
+func (c *Config) TLSEnabled() bool {
+	return c.Server.TLSCertFile != "" && c.Server.TLSKeyFile != ""
+}
+
+// Load builds the configuration from defaults, then the YAML config file,
+// then ERGRACER_* environment variables, and validates the result. The config
+// file may be absent when CONFIG_FILE_PATH is not set, so the service can be
+// configured from the environment alone.
+func Load() (*Config, error) {
+	config := defaults()
+
+	configPath, explicit := getConfigPath()
 	data, err := os.ReadFile(configPath)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to read config file '%s': %v", configPath, err))
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file '%s': %w", configPath, err)
+		}
+	case errors.Is(err, fs.ErrNotExist) && !explicit:
+		// Rely on the environment.
+	default:
+		return nil, fmt.Errorf("failed to read config file '%s': %w", configPath, err)
+	}
+
+	if err := applyEnv(config); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func defaults() *Config {
+	return &Config{
+		Mail: MailConfig{
+			SMTP: SMTPConfig{Port: 587},
+		},
+		App: AppConfig{
+			URL:  "http://localhost:8080",
+			Port: 8080,
+		},
+		Server: ServerConfig{
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			ShutdownTimeout:   30 * time.Second,
+			TrustedProxies:    []string{"127.0.0.1", "::1"},
+		},
+		Log: LogConfig{
+			Format: "json",
+			Level:  "info",
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+			ServiceName: "ergracer-api",
+		},
+		Jobs: JobsConfig{
+			Enabled:              true,
+			IdleRaceTimeout:      2 * time.Hour,
+			UnverifiedAccountTTL: 7 * 24 * time.Hour,
+			RaceUpdateRetention:  30 * 24 * time.Hour,
+		},
+	}
+}
+
+// minSecretLength is the shortest JWT or refresh token secret accepted.
+const minSecretLength = 32
+
+// placeholderSecrets are example values that must never be used as secrets.
+var placeholderSecrets = []string{
+	"your-super-secret-jwt-key-change-in-production",
+	"your-super-secret-refresh-key-change-in-production",
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Database.URL == "" {
+		errs = append(errs, errors.New("database.url is required"))
+	} else if u, err := url.Parse(c.Database.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		errs = append(errs, errors.New("database.url must be a postgres:// URL"))
+	}
+
+	errs = append(errs, validateSecret("jwt.secret", c.JWT.Secret, true))
+	errs = append(errs, validateSecret("jwt.refresh_secret", c.JWT.RefreshSecret, false))
+
+	if u, err := url.Parse(c.App.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("app.url must be an absolute http(s) URL, got %q", c.App.URL))
+	}
+	if c.App.Port < 1 || c.App.Port > 65535 {
+		errs = append(errs, fmt.Errorf("app.port must be between 1 and 65535, got %d", c.App.Port))
+	}
+
+	errs = append(errs, c.validateServer()...)
+	errs = append(errs, c.validateMail()...)
+
+	if !slices.Contains([]string{"json", "text"}, c.Log.Format) {
+		errs = append(errs, fmt.Errorf("log.format must be json or text, got %q", c.Log.Format))
+	}
+	if !slices.Contains([]string{"debug", "info", "warn", "error"}, c.Log.Level) {
+		errs = append(errs, fmt.Errorf("log.level must be debug, info, warn or error, got %q", c.Log.Level))
+	}
+
+	if !slices.Contains([]string{"none", "stdout", "otlp"}, c.Tracing.Exporter) {
+		errs = append(errs, fmt.Errorf("tracing.exporter must be none, stdout or otlp, got %q", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing.sample_ratio must be between 0 and 1, got %g", c.Tracing.SampleRatio))
+	}
+	if c.Tracing.ServiceName == "" {
+		errs = append(errs, errors.New("tracing.service_name is required"))
+	}
+
+	if c.Jobs.IdleRaceTimeout <= 0 || c.Jobs.UnverifiedAccountTTL <= 0 || c.Jobs.RaceUpdateRetention <= 0 {
+		errs = append(errs, errors.New("jobs.idle_race_timeout, jobs.unverified_account_ttl and jobs.race_update_retention must be positive"))
 	}
-	
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		panic(fmt.Sprintf("Failed to parse config file '%s': %v", configPath, err))
+	// Unverified accounts must outlive their verification link.
+	if c.Jobs.UnverifiedAccountTTL > 0 && c.Jobs.UnverifiedAccountTTL < 24*time.Hour {
+		errs = append(errs, errors.New("jobs.unverified_account_ttl must be at least 24h"))
 	}
-	
-	return &config
+
+	return errors.Join(errs...)
+}
+
+func validateSecret(name, secret string, required bool) error {
+	switch {
+	case secret == "":
+		if required {
+			return fmt.Errorf("%s is required", name)
+		}
+		return nil
+	case slices.Contains(placeholderSecrets, secret):
+		return fmt.Errorf("%s is still set to the example value", name)
+	case len(secret) < minSecretLength:
+		return fmt.Errorf("%s must be at least %d characters", name, minSecretLength)
+	}
+	return nil
 }
 
-func getConfigPath() string {
+func (c *Config) validateServer() []error {
+	var errs []error
+
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"server.drain_delay", c.Server.DrainDelay},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %s", timeout.name, timeout.value))
+		}
+	}
+	if c.Server.MaxHeaderBytes < 0 {
+		errs = append(errs, fmt.Errorf("server.max_header_bytes must not be negative, got %d", c.Server.MaxHeaderBytes))
+	}
+
+	for _, proxy := range c.Server.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				errs = append(errs, fmt.Errorf("server.trusted_proxies entry %q is not an IP address or CIDR range", proxy))
+			}
+		}
+	}
+
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		errs = append(errs, errors.New("server.tls_cert_file and server.tls_key_file must be set together"))
+	}
+	return errs
+}
+
+func (c *Config) validateMail() []error {
+	var errs []error
+
+	switch c.MailDriver() {
+	case MailDriverMailgun:
+		if c.Mailgun.Domain == "" || c.Mailgun.APIKey == "" {
+			errs = append(errs, errors.New("mailgun.domain and mailgun.api_key are required by the mailgun mail driver"))
+		}
+	case MailDriverSMTP:
+		if c.Mail.SMTP.Host == "" {
+			errs = append(errs, errors.New("mail.smtp.host is required by the smtp mail driver"))
+		}
+		if c.Mail.SMTP.Port < 1 || c.Mail.SMTP.Port > 65535 {
+			errs = append(errs, fmt.Errorf("mail.smtp.port must be between 1 and 65535, got %d", c.Mail.SMTP.Port))
+		}
+	case MailDriverLog:
+		return nil
+	case "":
+		return []error{fmt.Errorf("mail.driver is required; set it to %s to log outgoing email instead of sending it", MailDriverLog)}
+	default:
+		return []error{fmt.Errorf("mail.driver must be one of %s, %s or %s, got %q", MailDriverMailgun, MailDriverSMTP, MailDriverLog, c.Mail.Driver)}
+	}
+
+	if c.MailFromEmail() == "" {
+		errs = append(errs, errors.New("mail.from_email is required to send email"))
+	} else if _, err := mail.ParseAddress(c.MailFromEmail()); err != nil {
+		errs = append(errs, fmt.Errorf("mail.from_email is not a valid address: %q", c.MailFromEmail()))
+	}
+	return errs
+}
+
+// getConfigPath returns the config file path and whether it was chosen
+// explicitly through CONFIG_FILE_PATH.
+func getConfigPath() (string, bool) {
 	if configPath := os.Getenv("CONFIG_FILE_PATH"); configPath != "" {
-		return configPath
+		return configPath, true
 	}
-	return "config.yaml"
-}
\ No newline at end of file
+	return "config.yaml", false
+}
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS password_reset_tokens;
+DROP TABLE IF EXISTS session_rotated_tokens;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS race_updates;
+DROP TABLE IF EXISTS race_participants;
+DROP TABLE IF EXISTS races;
+DROP TABLE IF EXISTS friendships;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,14 @@
+CREATE TABLE job_runs (
+	id SERIAL PRIMARY KEY,
+	job_name VARCHAR(100) NOT NULL,
+	instance VARCHAR(255) NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'running',
+	affected_rows BIGINT,
+	error TEXT,
+	started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	finished_at TIMESTAMP
+);
+
+CREATE INDEX idx_job_runs_job_name_started_at ON job_runs(job_name, started_at DESC);
+
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
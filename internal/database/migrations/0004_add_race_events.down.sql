@@ -0,0 +1,5 @@
+ALTER TABLE race_participants DROP CONSTRAINT race_participants_status_check;
+
+ALTER TABLE races DROP CONSTRAINT races_status_check;
+
+DROP TABLE race_events;
@@ -0,0 +1,19 @@
+-- Race and participant statuses are only changed through the state machine
+-- in models/race_state.go, which records every transition here.
+CREATE TABLE race_events (
+	id BIGSERIAL PRIMARY KEY,
+	race_id INTEGER NOT NULL REFERENCES races(id) ON DELETE CASCADE,
+	user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	entity VARCHAR(20) NOT NULL,
+	from_status VARCHAR(20),
+	to_status VARCHAR(20) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_race_events_race_id ON race_events(race_id, id);
+
+ALTER TABLE races ADD CONSTRAINT races_status_check
+	CHECK (status IN ('waiting', 'countdown', 'active', 'finished', 'abandoned'));
+
+ALTER TABLE race_participants ADD CONSTRAINT race_participants_status_check
+	CHECK (status IN ('not_ready', 'ready', 'racing', 'finished'));
@@ -0,0 +1,5 @@
+DROP TRIGGER race_events_append_only ON race_events;
+DROP FUNCTION race_events_append_only();
+
+ALTER TABLE race_events DROP COLUMN data;
+ALTER TABLE race_events DROP COLUMN type;
@@ -0,0 +1,37 @@
+-- race_events becomes the race timeline: every entry gets a type naming what
+-- happened and optional details, and entries can no longer be changed.
+ALTER TABLE race_events ADD COLUMN type VARCHAR(50);
+ALTER TABLE race_events ADD COLUMN data JSONB;
+
+UPDATE race_events SET type = CASE
+	WHEN entity = 'race' AND from_status IS NULL THEN 'race_created'
+	WHEN entity = 'race' AND to_status = 'countdown' THEN 'countdown_started'
+	WHEN entity = 'race' AND to_status = 'active' THEN 'race_started'
+	WHEN entity = 'race' AND to_status = 'finished' THEN 'race_finished'
+	WHEN entity = 'race' AND to_status = 'abandoned' THEN 'race_abandoned'
+	WHEN from_status IS NULL THEN 'joined'
+	WHEN to_status = 'ready' THEN 'readied'
+	WHEN to_status = 'not_ready' THEN 'unreadied'
+	WHEN to_status = 'racing' THEN 'started_racing'
+	ELSE 'finished'
+END;
+
+ALTER TABLE race_events ALTER COLUMN type SET NOT NULL;
+
+-- Deletes and updates cascading from races and users run one trigger level
+-- deeper and are still allowed.
+CREATE FUNCTION race_events_append_only() RETURNS trigger AS $$
+BEGIN
+	IF pg_trigger_depth() > 1 THEN
+		IF TG_OP = 'DELETE' THEN
+			RETURN OLD;
+		END IF;
+		RETURN NEW;
+	END IF;
+	RAISE EXCEPTION 'race_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER race_events_append_only
+	BEFORE UPDATE OR DELETE ON race_events
+	FOR EACH ROW EXECUTE FUNCTION race_events_append_only();
@@ -0,0 +1,4 @@
+-- A short code rowers can type to join a race. Races created before it was
+-- introduced have none and are joined by UUID.
+ALTER TABLE races ADD COLUMN join_code VARCHAR(6);
+ALTER TABLE races ADD CONSTRAINT races_join_code_key UNIQUE (join_code);
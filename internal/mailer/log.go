@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// LogMailer is a development backend that never delivers anything. Messages
+// are written as .eml files to a directory when one is configured, and to the
+// log otherwise.
+type LogMailer struct {
+	dir  string
+	from Address
+}
+
+func NewLogMailer(dir string, from Address) *LogMailer {
+	return &LogMailer{dir: dir, from: from}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if m.dir == "" {
+		slog.InfoContext(ctx, "email", "to", msg.To, "subject", msg.Subject, "text", msg.Text)
+		return nil
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml",
+		time.Now().Format("20060102T150405.000000000"),
+		unsafeFileChars.ReplaceAllString(msg.To, "_"),
+	)
+
+	f, err := os.Create(filepath.Join(m.dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := mimeMessage(m.from, msg).WriteTo(f); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
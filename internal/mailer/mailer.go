@@ -0,0 +1,73 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+
+	"ergracer-api/internal/config"
+
+	"gopkg.in/gomail.v2"
+)
+
+// Message is a transactional email with a plain text and an HTML body.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers transactional email.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New builds the Mailer selected by mail.driver. Without a driver it falls
+// back to Mailgun when Mailgun is configured.
+func New(cfg *config.Config) (Mailer, error) {
+	from := Address{Email: cfg.MailFromEmail(), Name: cfg.MailFromName()}
+
+	switch cfg.MailDriver() {
+	case config.MailDriverMailgun:
+		if cfg.MailgunDomain() == "" || cfg.MailgunAPIKey() == "" {
+			return nil, fmt.Errorf("Mailgun not configured")
+		}
+		return NewMailgunMailer(cfg.MailgunDomain(), cfg.MailgunAPIKey(), from), nil
+	case config.MailDriverSMTP:
+		if cfg.Mail.SMTP.Host == "" {
+			return nil, fmt.Errorf("SMTP not configured")
+		}
+		return NewSMTPMailer(cfg.Mail.SMTP.Host, cfg.Mail.SMTP.Port, cfg.Mail.SMTP.Username, cfg.Mail.SMTP.Password, from), nil
+	case config.MailDriverLog:
+		return NewLogMailer(cfg.Mail.Dir, from), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Mail.Driver)
+	}
+}
+
+// Address is the sender of outgoing email.
+type Address struct {
+	Email string
+	Name  string
+}
+
+func (a Address) String() string {
+	if a.Name == "" {
+		return a.Email
+	}
+	return fmt.Sprintf("%s <%s>", a.Name, a.Email)
+}
+
+// mimeMessage renders msg as a multipart MIME message, as sent over SMTP and
+// written by the log mailer.
+func mimeMessage(from Address, msg Message) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetAddressHeader("From", from.Email, from.Name)
+	m.SetHeader("To", msg.To)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.Text)
+	if msg.HTML != "" {
+		m.AddAlternative("text/html", msg.HTML)
+	}
+	return m
+}
@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"context"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v5"
+)
+
+// MailgunMailer sends email through the Mailgun HTTP API.
+type MailgunMailer struct {
+	client *mailgun.Client
+	domain string
+	from   Address
+}
+
+func NewMailgunMailer(domain, apiKey string, from Address) *MailgunMailer {
+	return &MailgunMailer{
+		client: mailgun.NewMailgun(apiKey),
+		domain: domain,
+		from:   from,
+	}
+}
+
+func (m *MailgunMailer) Send(ctx context.Context, msg Message) error {
+	message := mailgun.NewMessage(
+		m.domain,
+		m.from.String(),
+		msg.Subject,
+		msg.Text,
+		msg.To,
+	)
+	if msg.HTML != "" {
+		message.SetHTML(msg.HTML)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	_, err := m.client.Send(ctx, message)
+	return err
+}
@@ -0,0 +1,28 @@
+package mailer
+
+import (
+	"context"
+
+	"gopkg.in/gomail.v2"
+)
+
+// SMTPMailer sends email through a plain SMTP server.
+type SMTPMailer struct {
+	dialer *gomail.Dialer
+	from   Address
+}
+
+func NewSMTPMailer(host string, port int, username, password string, from Address) *SMTPMailer {
+	return &SMTPMailer{
+		dialer: gomail.NewDialer(host, port, username, password),
+		from:   from,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.dialer.DialAndSend(mimeMessage(m.from, msg))
+}
@@ -0,0 +1,169 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Transactional email templates.
+const (
+	TemplateVerification  = "verification"
+	TemplatePasswordReset = "password_reset"
+	TemplateFriendRequest = "friend_request"
+	TemplateRaceInvite    = "race_invite"
+	TemplateRaceResults   = "race_results"
+)
+
+// DefaultLocale is used for users without a locale and for locales that have
+// no templates.
+const DefaultLocale = "en"
+
+// SupportedLocales lists the locales templates exist for.
+var SupportedLocales = []string{"en", "es"}
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// Templates renders transactional emails. Every email has a text template
+// ({locale}/{name}.txt.tmpl) that defines a "subject" block, and an HTML
+// template ({locale}/{name}.html.tmpl) that defines a "content" block wrapped
+// by layout.html.tmpl.
+type Templates struct {
+	fsys fs.FS
+}
+
+// NewTemplates uses the templates embedded in the binary, or the ones in dir
+// if it is not empty. Templates are parsed on every render, so edits to files
+// in dir show up without a restart.
+func NewTemplates(dir string) *Templates {
+	if dir != "" {
+		return &Templates{fsys: os.DirFS(dir)}
+	}
+
+	fsys, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		panic(err)
+	}
+	return &Templates{fsys: fsys}
+}
+
+// NormalizeLocale maps a locale such as "es-MX" to the closest supported one.
+func NormalizeLocale(locale string) string {
+	if language := baseLanguage(locale); IsSupportedLocale(language) {
+		return language
+	}
+	return DefaultLocale
+}
+
+// IsSupportedLocale reports whether there are templates for the language of
+// locale, ignoring any region suffix.
+func IsSupportedLocale(locale string) bool {
+	language := baseLanguage(locale)
+	for _, supported := range SupportedLocales {
+		if language == supported {
+			return true
+		}
+	}
+	return false
+}
+
+func baseLanguage(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "-_,;"); i >= 0 {
+		locale = locale[:i]
+	}
+	return locale
+}
+
+// Render builds the named email for the given recipient and locale. data is
+// available to the templates together with the Locale and Subject keys.
+func (t *Templates) Render(name, locale, to string, data map[string]interface{}) (Message, error) {
+	locale = NormalizeLocale(locale)
+
+	vars := make(map[string]interface{}, len(data)+2)
+	for k, v := range data {
+		vars[k] = v
+	}
+	vars["Locale"] = locale
+
+	textTmpl, err := texttemplate.ParseFS(t.fsys, path.Join(locale, name+".txt.tmpl"))
+	if err != nil {
+		return Message{}, err
+	}
+
+	var subject, text bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&subject, "subject", vars); err != nil {
+		return Message{}, err
+	}
+	if err := textTmpl.Execute(&text, vars); err != nil {
+		return Message{}, err
+	}
+	vars["Subject"] = strings.TrimSpace(subject.String())
+
+	htmlTmpl, err := htmltemplate.ParseFS(t.fsys,
+		"layout.html.tmpl",
+		path.Join(locale, "common.html.tmpl"),
+		path.Join(locale, name+".html.tmpl"),
+	)
+	if err != nil {
+		return Message{}, err
+	}
+
+	var html bytes.Buffer
+	if err := htmlTmpl.ExecuteTemplate(&html, "layout", vars); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      to,
+		Subject: vars["Subject"].(string),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+// SampleData returns example data for a template, used to preview emails.
+func SampleData(name string) (map[string]interface{}, bool) {
+	switch name {
+	case TemplateVerification:
+		return map[string]interface{}{
+			"VerifyURL": "https://ergracer.example.com/api/v1/auth/verify-email?token=sample-token",
+		}, true
+	case TemplatePasswordReset:
+		return map[string]interface{}{
+			"ResetURL": "https://ergracer.example.com/reset-password?token=sample-token",
+			"Token":    "sample-token",
+		}, true
+	case TemplateFriendRequest:
+		return map[string]interface{}{
+			"Username":     "stroke_seat",
+			"FromUsername": "bow_seat",
+			"AppURL":       "https://ergracer.example.com",
+		}, true
+	case TemplateRaceInvite:
+		return map[string]interface{}{
+			"Username":     "stroke_seat",
+			"FromUsername": "bow_seat",
+			"Distance":     2000,
+			"RaceUUID":     "3f2b8c1e-5d4a-4e6f-9a7b-1c2d3e4f5a6b",
+			"JoinCode":     "K7M2QX",
+		}, true
+	case TemplateRaceResults:
+		return map[string]interface{}{
+			"Username": "stroke_seat",
+			"Distance": 2000,
+			"Results": []map[string]interface{}{
+				{"Position": 1, "Username": "bow_seat", "Pace": "01:45"},
+				{"Position": 2, "Username": "stroke_seat", "Pace": "01:48"},
+			},
+		}, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,215 @@
+// Package metrics defines the Prometheus metrics exposed at /metrics.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ergracer"
+
+// Registry holds every ergracer metric plus the Go runtime and process
+// collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// RacesCreated counts races created.
+	RacesCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "races_created_total",
+		Help:      "Races created.",
+	})
+
+	// RacesStarted counts races whose countdown ended and that became active.
+	RacesStarted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "races_started_total",
+		Help:      "Races started after their countdown.",
+	})
+
+	// RacesFinished counts races finished by their last participant
+	// crossing the line.
+	RacesFinished = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "races_finished_total",
+		Help:      "Races finished by all participants.",
+	})
+
+	// ProgressUpdates counts accepted progress updates.
+	ProgressUpdates = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "race_progress_updates_total",
+		Help:      "Progress updates recorded.",
+	})
+
+	// CountdownFailures counts countdowns after which the race could not be
+	// started.
+	CountdownFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "race_countdown_failures_total",
+		Help:      "Countdowns after which starting the race failed.",
+	})
+
+	// EmailSendFailures counts emails that could not be rendered or sent.
+	EmailSendFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "email_send_failures_total",
+		Help:      "Emails that could not be sent, by template.",
+	}, []string{"template"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpRequestDuration,
+		RacesCreated,
+		RacesStarted,
+		RacesFinished,
+		ProgressUpdates,
+		CountdownFailures,
+		EmailSendFailures,
+	)
+}
+
+// RegisterDB adds connection pool statistics and race counts read from db.
+func RegisterDB(db *sql.DB) error {
+	if err := Registry.Register(collectors.NewDBStatsCollector(db, namespace)); err != nil {
+		return err
+	}
+	return Registry.Register(newRaceCollector(db))
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry}))
+}
+
+// Middleware records the latency and status of every request under the route
+// pattern that matched it, so path parameters do not create new series.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		httpRequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// raceCollector reports the number of unfinished races per status, read from
+// the database on every scrape so all replicas agree.
+type raceCollector struct {
+	db               *sql.DB
+	races            *prometheus.Desc
+	overdueCountdown *prometheus.Desc
+	scrapeErrors     *prometheus.Desc
+}
+
+// overdueCountdownGrace is how long after countdown_at a race may still be in
+// countdown before it is reported as overdue.
+const overdueCountdownGrace = 30 * time.Second
+
+func newRaceCollector(db *sql.DB) *raceCollector {
+	return &raceCollector{
+		db: db,
+		races: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "races"),
+			"Races that have not finished, by status.",
+			[]string{"status"}, nil,
+		),
+		overdueCountdown: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "races_countdown_overdue"),
+			"Races still in countdown well after their countdown ended.",
+			nil, nil,
+		),
+		scrapeErrors: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "races_scrape_error"),
+			"1 if the race counts could not be read on this scrape.",
+			nil, nil,
+		),
+	}
+}
+
+func (rc *raceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rc.races
+	ch <- rc.overdueCountdown
+	ch <- rc.scrapeErrors
+}
+
+func (rc *raceCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	counts := map[string]int{"waiting": 0, "countdown": 0, "active": 0}
+	var overdue int
+
+	err := rc.collect(ctx, counts, &overdue)
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(rc.scrapeErrors, prometheus.GaugeValue, 1)
+		return
+	}
+
+	for status, count := range counts {
+		ch <- prometheus.MustNewConstMetric(rc.races, prometheus.GaugeValue, float64(count), status)
+	}
+	ch <- prometheus.MustNewConstMetric(rc.overdueCountdown, prometheus.GaugeValue, float64(overdue))
+	ch <- prometheus.MustNewConstMetric(rc.scrapeErrors, prometheus.GaugeValue, 0)
+}
+
+func (rc *raceCollector) collect(ctx context.Context, counts map[string]int, overdue *int) error {
+	rows, err := rc.db.QueryContext(ctx, `
+		SELECT status, COUNT(*) FROM races
+		WHERE status IN ('waiting', 'countdown', 'active')
+		GROUP BY status`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return err
+		}
+		counts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return rc.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM races
+		WHERE status = 'countdown' AND countdown_at < $1`,
+		time.Now().Add(-overdueCountdownGrace),
+	).Scan(overdue)
+}
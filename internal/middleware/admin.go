@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"ergracer-api/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminRequired only lets admins through. It must run after AuthRequired.
+// Admin status is looked up on every request rather than carried in the
+// token, so revoking it takes effect immediately.
+func AdminRequired(isAdmin func(ctx context.Context, userID int) (bool, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		admin, err := isAdmin(c.Request.Context(), userID.(int))
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error("failed to check admin status", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+			c.Abort()
+			return
+		}
+
+		if !admin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
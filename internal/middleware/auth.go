@@ -1,17 +1,35 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
+	"ergracer-api/internal/logging"
 	"ergracer-api/internal/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AuthRequired(jwtSecret string) gin.HandlerFunc {
+// SessionChecker reports whether the session an access token was issued for
+// still exists.
+type SessionChecker func(ctx context.Context, userID, sessionID int) (bool, error)
+
+// AuthRequired only lets requests with a valid access token through. The
+// session named in the token is looked up on every request, so logging a
+// device out locks it out immediately rather than when its token expires.
+func AuthRequired(jwtSecret string, sessionActive SessionChecker) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" && (c.IsWebsocket() || isEventStream(c)) {
+			// Browsers cannot set headers on WebSocket handshakes or
+			// EventSource requests, so the token may be passed as a query
+			// parameter instead.
+			if token := c.Query("access_token"); token != "" {
+				authHeader = "Bearer " + token
+			}
+		}
+
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
 			c.Abort()
@@ -25,23 +43,44 @@ func AuthRequired(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
-		userID, err := utils.ValidateJWT(tokenString, jwtSecret)
+		claims, err := utils.ValidateJWT(tokenString, jwtSecret)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		c.Set("user_id", userID)
+		active, err := sessionActive(c.Request.Context(), claims.UserID, claims.SessionID)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error("failed to check session", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check session"})
+			c.Abort()
+			return
+		}
+
+		if !active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", claims.UserID)
+		c.Set("session_id", claims.SessionID)
+		AddLogAttrs(c, "user_id", claims.UserID)
 		c.Next()
 	}
 }
 
+func isEventStream(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
+
 func CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, Last-Event-ID, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ergracer-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testJWTSecret = "test-secret"
+
+// fakeSessions holds the sessions that have not been revoked, keyed by ID and
+// mapped to their user.
+type fakeSessions map[int]int
+
+func (f fakeSessions) active(ctx context.Context, userID, sessionID int) (bool, error) {
+	owner, ok := f[sessionID]
+	return ok && owner == userID, nil
+}
+
+// serveProtected runs one request with the given access token through
+// AuthRequired and returns the response.
+func serveProtected(t *testing.T, sessionActive SessionChecker, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	router := gin.New()
+	router.GET("/profile", AuthRequired(testJWTSecret, sessionActive), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": c.GetInt("user_id"), "session_id": c.GetInt("session_id")})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthRequiredSession(t *testing.T) {
+	sessions := fakeSessions{10: 1, 20: 2}
+
+	tests := []struct {
+		name      string
+		userID    int
+		sessionID int
+		want      int
+	}{
+		{"active session", 1, 10, http.StatusOK},
+		{"revoked session", 1, 11, http.StatusUnauthorized},
+		{"session of another user", 1, 20, http.StatusUnauthorized},
+		{"token without a session", 1, 0, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := utils.GenerateJWT(tt.userID, tt.sessionID, testJWTSecret)
+			if err != nil {
+				t.Fatalf("GenerateJWT() error = %v", err)
+			}
+
+			if w := serveProtected(t, sessions.active, token); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthRequiredRevokedAfterLogin(t *testing.T) {
+	sessions := fakeSessions{10: 1}
+	token, err := utils.GenerateJWT(1, 10, testJWTSecret)
+	if err != nil {
+		t.Fatalf("GenerateJWT() error = %v", err)
+	}
+
+	if w := serveProtected(t, sessions.active, token); w.Code != http.StatusOK {
+		t.Fatalf("before logout: status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	delete(sessions, 10)
+
+	if w := serveProtected(t, sessions.active, token); w.Code != http.StatusUnauthorized {
+		t.Errorf("after logout: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAuthRequiredSessionCheckFails(t *testing.T) {
+	failing := func(ctx context.Context, userID, sessionID int) (bool, error) {
+		return false, errors.New("connection refused")
+	}
+	token, err := utils.GenerateJWT(1, 10, testJWTSecret)
+	if err != nil {
+		t.Fatalf("GenerateJWT() error = %v", err)
+	}
+
+	if w := serveProtected(t, failing, token); w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ergracer-api/internal/logging"
+	"ergracer-api/internal/models"
+	"ergracer-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RaceAuthorizer loads a race and checks that a user may perform an action on
+// it, returning services.ErrRaceNotFound or one of the services rule errors.
+type RaceAuthorizer func(ctx context.Context, ref services.RaceRef, userID int, action services.RaceAction) (*models.Race, error)
+
+// RaceAccessRequired resolves the race named by the :uuid route parameter and
+// only lets the caller through if they may perform action on it. The race is
+// stored in the context under "race". It must run after AuthRequired.
+//
+// Numeric race IDs are still accepted in place of the UUID for one release;
+// such responses carry a Deprecation header.
+func RaceAccessRequired(authorize RaceAuthorizer, action services.RaceAction) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		ref, ok := parseRaceRef(c.Param("uuid"))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid race ID"})
+			c.Abort()
+			return
+		}
+		if ref.ID != 0 {
+			c.Header("Deprecation", "true")
+			logging.FromContext(c.Request.Context()).Info("deprecated numeric race ID used", "race_id", ref.ID)
+		}
+
+		race, err := authorize(c.Request.Context(), ref, userID.(int), action)
+		switch {
+		case err == nil:
+		case errors.Is(err, services.ErrRaceNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Race not found"})
+			c.Abort()
+			return
+		case errors.Is(err, services.ErrNotRaceParticipant):
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not a participant in this race"})
+			c.Abort()
+			return
+		case errors.Is(err, services.ErrNotRaceCreator):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the race creator can do this"})
+			c.Abort()
+			return
+		default:
+			logging.FromContext(c.Request.Context()).Error("failed to check race access", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check race access"})
+			c.Abort()
+			return
+		}
+
+		c.Set("race", race)
+		c.Next()
+	}
+}
+
+// parseRaceRef reads a race UUID or a deprecated numeric race ID.
+func parseRaceRef(param string) (services.RaceRef, bool) {
+	if id, err := strconv.Atoi(param); err == nil {
+		return services.RaceRef{ID: id}, id > 0
+	}
+	raceUUID, ok := services.NormalizeRaceUUID(param)
+	return services.RaceRef{UUID: raceUUID}, ok
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ergracer-api/internal/models"
+	"ergracer-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+const raceUUID = "3f2b8c1e-5d4a-4e6f-9a7b-1c2d3e4f5a6b"
+
+// fakeRaces authorizes against races held in memory using the real rules.
+type fakeRaces struct {
+	races        map[int]*models.Race
+	participants map[int][]int
+	err          error
+}
+
+func (f *fakeRaces) authorize(ctx context.Context, ref services.RaceRef, userID int, action services.RaceAction) (*models.Race, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var access services.RaceAccess
+	for _, race := range f.races {
+		if race.ID == ref.ID || (ref.UUID != "" && race.UUID == ref.UUID) {
+			access.Race = race
+		}
+	}
+	if access.Race == nil {
+		return nil, services.ErrRaceNotFound
+	}
+	for _, id := range f.participants[access.Race.ID] {
+		access.Participant = access.Participant || id == userID
+	}
+	if err := access.Authorize(userID, action); err != nil {
+		return nil, err
+	}
+	return access.Race, nil
+}
+
+// serveRaceRoute runs one request through RaceAccessRequired. userID 0 means
+// unauthenticated. It returns the response and, on success, the ID of the race
+// the handler found in the context.
+func serveRaceRoute(t *testing.T, authorize RaceAuthorizer, action services.RaceAction, userID int, raceParam string) (*httptest.ResponseRecorder, int) {
+	t.Helper()
+
+	var gotRaceID int
+	router := gin.New()
+	router.POST("/races/:uuid/start",
+		func(c *gin.Context) {
+			if userID != 0 {
+				c.Set("user_id", userID)
+			}
+		},
+		RaceAccessRequired(authorize, action),
+		func(c *gin.Context) {
+			gotRaceID = c.MustGet("race").(*models.Race).ID
+			c.Status(http.StatusOK)
+		},
+	)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/races/"+raceParam+"/start", nil))
+
+	if w.Code != http.StatusOK {
+		var body map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["error"] == "" {
+			t.Errorf("status %d response has no error message: %s", w.Code, w.Body.String())
+		}
+	}
+	return w, gotRaceID
+}
+
+func TestRaceAccessRequired(t *testing.T) {
+	const creator, participant, outsider = 1, 2, 3
+	races := &fakeRaces{
+		races:        map[int]*models.Race{7: {ID: 7, UUID: raceUUID, CreatedBy: creator}},
+		participants: map[int][]int{7: {creator, participant}},
+	}
+
+	tests := []struct {
+		name       string
+		action     services.RaceAction
+		userID     int
+		raceParam  string
+		wantStatus int
+	}{
+		{"participant may participate", services.RaceActionParticipate, participant, raceUUID, http.StatusOK},
+		{"upper-case UUID", services.RaceActionParticipate, participant, strings.ToUpper(raceUUID), http.StatusOK},
+		{"participant may participate by ID", services.RaceActionParticipate, participant, "7", http.StatusOK},
+		{"creator may participate", services.RaceActionParticipate, creator, "7", http.StatusOK},
+		{"outsider may not participate", services.RaceActionParticipate, outsider, "7", http.StatusForbidden},
+		{"creator may manage", services.RaceActionManage, creator, raceUUID, http.StatusOK},
+		{"creator may manage by ID", services.RaceActionManage, creator, "7", http.StatusOK},
+		{"participant may not manage", services.RaceActionManage, participant, "7", http.StatusForbidden},
+		{"outsider may not manage", services.RaceActionManage, outsider, "7", http.StatusForbidden},
+		{"unknown race", services.RaceActionParticipate, participant, "5d0c2b6e-8f3a-4d71-9e2b-6a4f1c3d8e90", http.StatusNotFound},
+		{"unknown race ID", services.RaceActionParticipate, participant, "8", http.StatusNotFound},
+		{"unknown race for manage", services.RaceActionManage, creator, "8", http.StatusNotFound},
+		{"malformed race ID", services.RaceActionParticipate, participant, "seven", http.StatusBadRequest},
+		{"non-positive race ID", services.RaceActionParticipate, participant, "0", http.StatusBadRequest},
+		{"truncated UUID", services.RaceActionParticipate, participant, raceUUID[:8], http.StatusBadRequest},
+		{"unauthenticated", services.RaceActionParticipate, 0, "7", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, raceID := serveRaceRoute(t, races.authorize, tt.action, tt.userID, tt.raceParam)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code == http.StatusOK && raceID != 7 {
+				t.Errorf("handler saw race %d, want 7", raceID)
+			}
+		})
+	}
+}
+
+func TestRaceAccessRequiredLookupFailure(t *testing.T) {
+	races := &fakeRaces{err: errors.New("connection refused")}
+
+	w, _ := serveRaceRoute(t, races.authorize, services.RaceActionParticipate, 1, raceUUID)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestRaceAccessRequiredDeprecatesNumericIDs(t *testing.T) {
+	races := &fakeRaces{
+		races:        map[int]*models.Race{7: {ID: 7, UUID: raceUUID, CreatedBy: 1}},
+		participants: map[int][]int{7: {1}},
+	}
+
+	tests := []struct {
+		raceParam       string
+		wantDeprecation string
+	}{
+		{raceUUID, ""},
+		{"7", "true"},
+	}
+
+	for _, tt := range tests {
+		w, _ := serveRaceRoute(t, races.authorize, services.RaceActionParticipate, 1, tt.raceParam)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", tt.raceParam, w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("Deprecation"); got != tt.wantDeprecation {
+			t.Errorf("%s: Deprecation = %q, want %q", tt.raceParam, got, tt.wantDeprecation)
+		}
+	}
+}
+
+func TestRaceAccessRequiredSkipsHandlerWhenDenied(t *testing.T) {
+	races := &fakeRaces{
+		races:        map[int]*models.Race{7: {ID: 7, UUID: raceUUID, CreatedBy: 1}},
+		participants: map[int][]int{7: {1}},
+	}
+
+	called := false
+	router := gin.New()
+	router.POST("/races/:uuid/progress",
+		func(c *gin.Context) { c.Set("user_id", 2) },
+		RaceAccessRequired(races.authorize, services.RaceActionParticipate),
+		func(c *gin.Context) { called = true },
+	)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/races/"+raceUUID+"/progress", nil))
+
+	if called {
+		t.Error("handler ran for a user who is not a participant")
+	}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
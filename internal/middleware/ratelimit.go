@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit allows each client IP at most limit requests per window on the
+// routes it is applied to. Counters live in memory, so with several replicas
+// the effective limit is per replica.
+func RateLimit(limit int, window time.Duration) gin.HandlerFunc {
+	type counter struct {
+		count   int
+		resetAt time.Time
+	}
+
+	var mu sync.Mutex
+	counters := make(map[string]*counter)
+	lastSweep := time.Now()
+
+	return func(c *gin.Context) {
+		now := time.Now()
+		ip := c.ClientIP()
+
+		mu.Lock()
+		if now.Sub(lastSweep) > window {
+			for key, ctr := range counters {
+				if now.After(ctr.resetAt) {
+					delete(counters, key)
+				}
+			}
+			lastSweep = now
+		}
+
+		ctr, ok := counters[ip]
+		if !ok || now.After(ctr.resetAt) {
+			ctr = &counter{resetAt: now.Add(window)}
+			counters[ip] = ctr
+		}
+		ctr.count++
+		exceeded := ctr.count > limit
+		mu.Unlock()
+
+		if exceeded {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please try again later"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Job run statuses.
+const (
+	JobRunRunning   = "running"
+	JobRunSucceeded = "succeeded"
+	JobRunFailed    = "failed"
+)
+
+// JobRun records one execution of a background job. Instance identifies the
+// replica that ran it.
+type JobRun struct {
+	ID           int        `json:"id" db:"id"`
+	JobName      string     `json:"job_name" db:"job_name"`
+	Instance     string     `json:"instance" db:"instance"`
+	Status       string     `json:"status" db:"status"`
+	AffectedRows *int64     `json:"affected_rows" db:"affected_rows"`
+	Error        *string    `json:"error,omitempty" db:"error"`
+	StartedAt    time.Time  `json:"started_at" db:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at" db:"finished_at"`
+}
+
+// JobStatus describes a registered job and its most recent run.
+type JobStatus struct {
+	Name     string  `json:"name"`
+	Interval string  `json:"interval"`
+	LastRun  *JobRun `json:"last_run"`
+}
@@ -1,14 +1,16 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
 type Race struct {
 	ID            int       `json:"id" db:"id"`
 	UUID          string    `json:"uuid" db:"uuid"`
+	JoinCode      *string   `json:"join_code" db:"join_code"` // nil for races created before join codes
 	Distance      int       `json:"distance" db:"distance"` // meters
-	Status        string    `json:"status" db:"status"`     // waiting, ready, countdown, active, finished
+	Status        RaceStatus `json:"status" db:"status"`
 	CreatedBy     int       `json:"created_by" db:"created_by"`
 	CreatedAt     time.Time `json:"created_at" db:"created_at"`
 	StartedAt     *time.Time `json:"started_at" db:"started_at"`
@@ -20,7 +22,7 @@ type RaceParticipant struct {
 	ID             int       `json:"id" db:"id"`
 	RaceID         int       `json:"race_id" db:"race_id"`
 	UserID         int       `json:"user_id" db:"user_id"`
-	Status         string    `json:"status" db:"status"`         // not_ready, ready, racing, finished
+	Status         ParticipantStatus `json:"status" db:"status"`
 	CurrentDistance int      `json:"current_distance" db:"current_distance"` // meters
 	FinishedAt     *time.Time `json:"finished_at" db:"finished_at"`
 	Pace           *string   `json:"pace" db:"pace"`             // mm:ss per 500m, calculated when finished
@@ -34,4 +36,59 @@ type RaceUpdate struct {
 	UserID    int       `json:"user_id" db:"user_id"`
 	Distance  int       `json:"distance" db:"distance"`
 	Timestamp time.Time `json:"timestamp" db:"timestamp"`
-}
\ No newline at end of file
+}
+
+// Race event types pushed to live race subscribers.
+const (
+	RaceEventSnapshot            = "snapshot"
+	RaceEventParticipantJoined   = "participant_joined"
+	RaceEventReadyChanged        = "ready_changed"
+	RaceEventCountdownStarted    = "countdown_started"
+	RaceEventRaceStarted         = "race_started"
+	RaceEventProgress            = "progress"
+	RaceEventParticipantFinished = "participant_finished"
+	RaceEventRaceFinished        = "race_finished"
+	// RaceEventReconnect asks event stream clients to reconnect because the
+	// server is shutting down.
+	RaceEventReconnect = "reconnect"
+)
+
+// RaceEvent is a change to a race pushed to live subscribers. ID is set for
+// events backed by the race_updates log and can be used to resume a feed.
+type RaceEvent struct {
+	ID        int         `json:"id,omitempty"`
+	Type      string      `json:"type"`
+	RaceID    int         `json:"race_id"`
+	UserID    int         `json:"user_id,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Types of the entries of a race's timeline, the append-only race_events log.
+const (
+	TimelineRaceCreated       = "race_created"
+	TimelineJoined            = "joined"
+	TimelineReadied           = "readied"
+	TimelineUnreadied         = "unreadied"
+	TimelineCountdownStarted  = "countdown_started"
+	TimelineRaceStarted       = "race_started"
+	TimelineStartedRacing     = "started_racing"
+	TimelineFinished          = "finished"
+	TimelineRaceFinished      = "race_finished"
+	TimelineRaceForceFinished = "race_force_finished"
+	TimelineRaceAbandoned     = "race_abandoned"
+)
+
+// RaceTimelineEvent is an entry of a race's timeline. UserID and Username are
+// set for entries about a participant, and FromStatus is empty when the race
+// was created or the participant joined.
+type RaceTimelineEvent struct {
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	UserID     *int            `json:"user_id,omitempty"`
+	Username   *string         `json:"username,omitempty"`
+	FromStatus *string         `json:"from_status,omitempty"`
+	ToStatus   string          `json:"to_status"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
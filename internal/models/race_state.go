@@ -0,0 +1,133 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RaceStatus is the state of a race. A race moves
+//
+//	waiting -> countdown -> active -> finished
+//
+// and a waiting race that nobody joins is abandoned. Countdown and active
+// races can also be finished early by an operator.
+type RaceStatus string
+
+const (
+	RaceWaiting   RaceStatus = "waiting"
+	RaceCountdown RaceStatus = "countdown"
+	RaceActive    RaceStatus = "active"
+	RaceFinished  RaceStatus = "finished"
+	RaceAbandoned RaceStatus = "abandoned"
+)
+
+var raceTransitions = map[RaceStatus][]RaceStatus{
+	RaceWaiting:   {RaceCountdown, RaceAbandoned},
+	RaceCountdown: {RaceActive, RaceFinished},
+	RaceActive:    {RaceFinished},
+}
+
+// CanTransitionTo reports whether a race may move from s to next.
+func (s RaceStatus) CanTransitionTo(next RaceStatus) bool {
+	for _, allowed := range raceTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionTo returns a *TransitionError if a race may not move from s to
+// next.
+func (s RaceStatus) TransitionTo(next RaceStatus) error {
+	if !s.CanTransitionTo(next) {
+		return &TransitionError{Entity: "race", From: string(s), To: string(next)}
+	}
+	return nil
+}
+
+// Terminal reports whether no further transitions are possible.
+func (s RaceStatus) Terminal() bool {
+	return len(raceTransitions[s]) == 0
+}
+
+// ParticipantStatus is the state of a participant within a race. Users join
+// a waiting race as not_ready, toggle between not_ready and ready while it is
+// waiting, all start racing when it becomes active, and finish on reaching
+// the distance. Progress updates keep a participant racing.
+type ParticipantStatus string
+
+const (
+	// ParticipantNone is the status of a user who has not joined the race.
+	ParticipantNone     ParticipantStatus = ""
+	ParticipantNotReady ParticipantStatus = "not_ready"
+	ParticipantReady    ParticipantStatus = "ready"
+	ParticipantRacing   ParticipantStatus = "racing"
+	ParticipantFinished ParticipantStatus = "finished"
+)
+
+var participantTransitions = map[ParticipantStatus][]ParticipantStatus{
+	ParticipantNone:     {ParticipantNotReady},
+	ParticipantNotReady: {ParticipantReady},
+	ParticipantReady:    {ParticipantNotReady, ParticipantRacing},
+	ParticipantRacing:   {ParticipantRacing, ParticipantFinished},
+}
+
+// participantRaceStatus is the race status in which a participant may move
+// to each status.
+var participantRaceStatus = map[ParticipantStatus]RaceStatus{
+	ParticipantNotReady: RaceWaiting,
+	ParticipantReady:    RaceWaiting,
+	ParticipantRacing:   RaceActive,
+	ParticipantFinished: RaceActive,
+}
+
+// CanTransitionTo reports whether a participant may move from s to next
+// while the race is in raceStatus.
+func (s ParticipantStatus) CanTransitionTo(next ParticipantStatus, raceStatus RaceStatus) bool {
+	if participantRaceStatus[next] != raceStatus {
+		return false
+	}
+	for _, allowed := range participantTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionTo returns a *TransitionError if a participant may not move from
+// s to next while the race is in raceStatus.
+func (s ParticipantStatus) TransitionTo(next ParticipantStatus, raceStatus RaceStatus) error {
+	if !s.CanTransitionTo(next, raceStatus) {
+		return &TransitionError{Entity: "participant", From: string(s), To: string(next), RaceStatus: raceStatus}
+	}
+	return nil
+}
+
+// ErrIllegalTransition matches every *TransitionError with errors.Is.
+var ErrIllegalTransition = errors.New("illegal state transition")
+
+// TransitionError is returned for a state change the race state machine does
+// not allow. RaceStatus is set for participant transitions.
+type TransitionError struct {
+	Entity     string
+	From       string
+	To         string
+	RaceStatus RaceStatus
+}
+
+func (e *TransitionError) Error() string {
+	from := e.From
+	if from == "" {
+		from = "not joined"
+	}
+	if e.RaceStatus != "" {
+		return fmt.Sprintf("%s cannot go from %s to %s while the race is %s", e.Entity, from, e.To, e.RaceStatus)
+	}
+	return fmt.Sprintf("%s cannot go from %s to %s", e.Entity, from, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrIllegalTransition
+}
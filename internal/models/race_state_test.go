@@ -0,0 +1,153 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+var (
+	allRaceStatuses = []RaceStatus{RaceWaiting, RaceCountdown, RaceActive, RaceFinished, RaceAbandoned}
+
+	allParticipantStatuses = []ParticipantStatus{
+		ParticipantNone, ParticipantNotReady, ParticipantReady, ParticipantRacing, ParticipantFinished,
+	}
+)
+
+func TestRaceStatusTransitions(t *testing.T) {
+	legal := map[[2]RaceStatus]bool{
+		{RaceWaiting, RaceCountdown}:  true,
+		{RaceWaiting, RaceAbandoned}:  true,
+		{RaceCountdown, RaceActive}:   true,
+		{RaceCountdown, RaceFinished}: true,
+		{RaceActive, RaceFinished}:    true,
+	}
+
+	for _, from := range allRaceStatuses {
+		for _, to := range allRaceStatuses {
+			want := legal[[2]RaceStatus{from, to}]
+			if got := from.CanTransitionTo(to); got != want {
+				t.Errorf("%s.CanTransitionTo(%s) = %v, want %v", from, to, got, want)
+			}
+
+			err := from.TransitionTo(to)
+			if want && err != nil {
+				t.Errorf("%s.TransitionTo(%s) = %v, want nil", from, to, err)
+			}
+			if !want && !errors.Is(err, ErrIllegalTransition) {
+				t.Errorf("%s.TransitionTo(%s) = %v, want ErrIllegalTransition", from, to, err)
+			}
+		}
+	}
+}
+
+func TestRaceStatusTerminal(t *testing.T) {
+	for _, status := range allRaceStatuses {
+		want := status == RaceFinished || status == RaceAbandoned
+		if got := status.Terminal(); got != want {
+			t.Errorf("%s.Terminal() = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestParticipantStatusTransitions(t *testing.T) {
+	type edge struct {
+		from, to ParticipantStatus
+		race     RaceStatus
+	}
+	legal := map[edge]bool{
+		{ParticipantNone, ParticipantNotReady, RaceWaiting}:  true,
+		{ParticipantNotReady, ParticipantReady, RaceWaiting}: true,
+		{ParticipantReady, ParticipantNotReady, RaceWaiting}: true,
+		{ParticipantReady, ParticipantRacing, RaceActive}:    true,
+		{ParticipantRacing, ParticipantRacing, RaceActive}:   true,
+		{ParticipantRacing, ParticipantFinished, RaceActive}: true,
+	}
+
+	for _, race := range allRaceStatuses {
+		for _, from := range allParticipantStatuses {
+			for _, to := range allParticipantStatuses {
+				want := legal[edge{from, to, race}]
+				if got := from.CanTransitionTo(to, race); got != want {
+					t.Errorf("%q.CanTransitionTo(%q, %s) = %v, want %v", from, to, race, got, want)
+				}
+
+				err := from.TransitionTo(to, race)
+				if want && err != nil {
+					t.Errorf("%q.TransitionTo(%q, %s) = %v, want nil", from, to, race, err)
+				}
+				if !want && !errors.Is(err, ErrIllegalTransition) {
+					t.Errorf("%q.TransitionTo(%q, %s) = %v, want ErrIllegalTransition", from, to, race, err)
+				}
+			}
+		}
+	}
+}
+
+// TestParticipantStatusRejects covers the changes the state machine was
+// introduced to stop.
+func TestParticipantStatusRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to ParticipantStatus
+		race     RaceStatus
+	}{
+		{"progress while waiting", ParticipantReady, ParticipantRacing, RaceWaiting},
+		{"progress during countdown", ParticipantReady, ParticipantRacing, RaceCountdown},
+		{"progress after finishing", ParticipantFinished, ParticipantRacing, RaceActive},
+		{"un-ready while active", ParticipantRacing, ParticipantNotReady, RaceActive},
+		{"ready while active", ParticipantRacing, ParticipantReady, RaceActive},
+		{"un-ready during countdown", ParticipantReady, ParticipantNotReady, RaceCountdown},
+		{"join during countdown", ParticipantNone, ParticipantNotReady, RaceCountdown},
+		{"join while active", ParticipantNone, ParticipantNotReady, RaceActive},
+		{"join after finish", ParticipantNone, ParticipantNotReady, RaceFinished},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.from.TransitionTo(tt.to, tt.race)
+			if !errors.Is(err, ErrIllegalTransition) {
+				t.Fatalf("TransitionTo = %v, want ErrIllegalTransition", err)
+			}
+
+			var transitionErr *TransitionError
+			if !errors.As(err, &transitionErr) {
+				t.Fatalf("TransitionTo = %T, want *TransitionError", err)
+			}
+			if transitionErr.Entity != "participant" || transitionErr.RaceStatus != tt.race {
+				t.Errorf("TransitionError = %+v, want a participant error while the race is %s", transitionErr, tt.race)
+			}
+		})
+	}
+}
+
+func TestTransitionError(t *testing.T) {
+	tests := []struct {
+		err  *TransitionError
+		want string
+	}{
+		{
+			&TransitionError{Entity: "race", From: "waiting", To: "active"},
+			"race cannot go from waiting to active",
+		},
+		{
+			&TransitionError{Entity: "participant", From: "ready", To: "racing", RaceStatus: RaceWaiting},
+			"participant cannot go from ready to racing while the race is waiting",
+		},
+		{
+			&TransitionError{Entity: "participant", To: "not_ready", RaceStatus: RaceActive},
+			"participant cannot go from not joined to not_ready while the race is active",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+		if !errors.Is(tt.err, ErrIllegalTransition) {
+			t.Errorf("errors.Is(%v, ErrIllegalTransition) = false, want true", tt.err)
+		}
+		if errors.Is(tt.err, errors.New("illegal state transition")) {
+			t.Errorf("errors.Is matched an unrelated error with the same text")
+		}
+	}
+}
@@ -11,6 +11,9 @@ type User struct {
 	PasswordHash      string    `json:"-" db:"password_hash"`
 	EmailVerified     bool      `json:"email_verified" db:"email_verified"`
 	EmailVerifyToken  *string   `json:"-" db:"email_verify_token"`
+	PendingEmail      *string   `json:"pending_email,omitempty" db:"pending_email"`
+	Locale            string    `json:"locale,omitempty" db:"locale"`
+	DisabledAt        *time.Time `json:"-" db:"disabled_at"`
 	CreatedAt         time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -19,6 +22,7 @@ type Session struct {
 	ID                 int       `json:"id" db:"id"`
 	UserID             int       `json:"user_id" db:"user_id"`
 	RefreshTokenHash   string    `json:"-" db:"refresh_token_hash"`
+	Generation         int       `json:"-" db:"generation"`
 	DeviceType         string    `json:"device_type" db:"device_type"`
 	UserAgent          string    `json:"user_agent" db:"user_agent"`
 	IPAddress          string    `json:"ip_address" db:"ip_address"`
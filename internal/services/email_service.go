@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"ergracer-api/internal/logging"
+	"ergracer-api/internal/mailer"
+	"ergracer-api/internal/metrics"
+	"ergracer-api/internal/models"
+	"ergracer-api/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// EmailService renders transactional emails in the recipient's locale and
+// hands them to the configured Mailer.
+type EmailService struct {
+	db        *sql.DB
+	mailer    mailer.Mailer
+	templates *mailer.Templates
+	appURL    string
+	logger    *slog.Logger
+
+	// pending counts sends started by SendInBackground.
+	pending sync.WaitGroup
+}
+
+func NewEmailService(db *sql.DB, m mailer.Mailer, templates *mailer.Templates, appURL string, logger *slog.Logger) *EmailService {
+	return &EmailService{
+		db:        db,
+		mailer:    m,
+		templates: templates,
+		appURL:    appURL,
+		logger:    logger,
+	}
+}
+
+func (s *EmailService) SendVerification(ctx context.Context, to, locale, token string) error {
+	return s.send(ctx, mailer.TemplateVerification, to, locale, map[string]interface{}{
+		"VerifyURL": fmt.Sprintf("%s/api/v1/auth/verify-email?token=%s", s.appURL, token),
+	})
+}
+
+func (s *EmailService) SendPasswordReset(ctx context.Context, to, locale, token string) error {
+	return s.send(ctx, mailer.TemplatePasswordReset, to, locale, map[string]interface{}{
+		"ResetURL": fmt.Sprintf("%s/reset-password?token=%s", s.appURL, token),
+		"Token":    token,
+	})
+}
+
+func (s *EmailService) SendFriendRequest(ctx context.Context, fromUserID, toUserID int) error {
+	from, err := s.getRecipient(fromUserID)
+	if err != nil {
+		return err
+	}
+
+	to, err := s.getRecipient(toUserID)
+	if err != nil {
+		return err
+	}
+
+	return s.send(ctx, mailer.TemplateFriendRequest, to.Email, to.Locale, map[string]interface{}{
+		"Username":     to.Username,
+		"FromUsername": from.Username,
+		"AppURL":       s.appURL,
+	})
+}
+
+func (s *EmailService) SendRaceInvite(ctx context.Context, fromUserID, toUserID int, race *models.Race) error {
+	from, err := s.getRecipient(fromUserID)
+	if err != nil {
+		return err
+	}
+
+	to, err := s.getRecipient(toUserID)
+	if err != nil {
+		return err
+	}
+
+	// Races created before join codes are joined by UUID.
+	joinCode := ""
+	if race.JoinCode != nil {
+		joinCode = *race.JoinCode
+	}
+
+	return s.send(ctx, mailer.TemplateRaceInvite, to.Email, to.Locale, map[string]interface{}{
+		"Username":     to.Username,
+		"FromUsername": from.Username,
+		"Distance":     race.Distance,
+		"RaceUUID":     race.UUID,
+		"JoinCode":     joinCode,
+	})
+}
+
+// SendRaceResults emails the final standings of a finished race to every
+// participant. A failure for one participant does not stop the others; the
+// last error is returned.
+func (s *EmailService) SendRaceResults(ctx context.Context, raceID int) (err error) {
+	ctx, span := tracing.Start(ctx, "EmailService.SendRaceResults", attribute.Int("race.id", raceID))
+	defer tracing.End(span, &err)
+
+	var distance int
+	err = s.db.QueryRowContext(ctx, "SELECT distance FROM races WHERE id = $1", raceID).Scan(&distance)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		SELECT u.id, u.email, u.username, u.locale, rp.position, rp.pace
+		FROM race_participants rp
+		JOIN users u ON rp.user_id = u.id
+		WHERE rp.race_id = $1
+		ORDER BY COALESCE(rp.position, 999), rp.joined_at`
+
+	rows, err := s.db.QueryContext(ctx, query, raceID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var recipients []models.User
+	var results []map[string]interface{}
+	for rows.Next() {
+		var user models.User
+		var position *int
+		var pace *string
+		err := rows.Scan(&user.ID, &user.Email, &user.Username, &user.Locale, &position, &pace)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, user)
+
+		result := map[string]interface{}{
+			"Position": "-",
+			"Username": user.Username,
+			"Pace":     "-",
+		}
+		if position != nil {
+			result["Position"] = *position
+		}
+		if pace != nil {
+			result["Pace"] = *pace
+		}
+		results = append(results, result)
+	}
+	rows.Close()
+
+	var lastErr error
+	for _, user := range recipients {
+		err := s.send(ctx, mailer.TemplateRaceResults, user.Email, user.Locale, map[string]interface{}{
+			"Username": user.Username,
+			"Distance": distance,
+			"Results":  results,
+		})
+		if err != nil {
+			logging.FromContextOr(ctx, s.logger).Error("failed to send race results",
+				"race_id", raceID, "recipient_id", user.ID, "error", err)
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+func (s *EmailService) send(ctx context.Context, name, to, locale string, data map[string]interface{}) (err error) {
+	ctx, span := tracing.Start(ctx, "EmailService.send", attribute.String("email.template", name))
+	defer tracing.End(span, &err)
+
+	msg, err := s.templates.Render(name, locale, to, data)
+	if err == nil {
+		err = s.mailer.Send(ctx, msg)
+	}
+	if err != nil {
+		metrics.EmailSendFailures.WithLabelValues(name).Inc()
+	}
+	return err
+}
+
+func (s *EmailService) getRecipient(userID int) (*models.User, error) {
+	var user models.User
+	err := s.db.QueryRow(
+		"SELECT id, email, username, locale FROM users WHERE id = $1",
+		userID,
+	).Scan(&user.ID, &user.Email, &user.Username, &user.Locale)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// SendInBackground runs an email send outside the request that triggered it,
+// logging failures instead of returning them. The send is not cancelled with
+// ctx but keeps its logger, so failures can be traced to the request. Wait
+// blocks until it has finished.
+func (s *EmailService) SendInBackground(ctx context.Context, description string, send func(ctx context.Context) error) {
+	ctx = context.WithoutCancel(ctx)
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		if err := send(ctx); err != nil {
+			logging.FromContextOr(ctx, s.logger).Error("failed to send email", "email", description, "error", err)
+		}
+	}()
+}
+
+// Wait blocks until every send started by SendInBackground has finished.
+func (s *EmailService) Wait() {
+	s.pending.Wait()
+}
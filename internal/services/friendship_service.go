@@ -1,10 +1,14 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
 	"ergracer-api/internal/models"
+	"ergracer-api/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type FriendshipService struct {
@@ -15,7 +19,10 @@ func NewFriendshipService(db *sql.DB) *FriendshipService {
 	return &FriendshipService{db: db}
 }
 
-func (s *FriendshipService) CanInviteFriend(userID, friendID int) (bool, error) {
+func (s *FriendshipService) CanInviteFriend(ctx context.Context, userID, friendID int) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "FriendshipService.CanInviteFriend", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
 	query := `
 		SELECT COUNT(*) > 0
 		FROM race_participants rp1
@@ -23,7 +30,7 @@ func (s *FriendshipService) CanInviteFriend(userID, friendID int) (bool, error)
 		WHERE rp1.user_id = $1 AND rp2.user_id = $2`
 	
 	var hasSharedRace bool
-	err := s.db.QueryRow(query, userID, friendID).Scan(&hasSharedRace)
+	err = s.db.QueryRowContext(ctx, query, userID, friendID).Scan(&hasSharedRace)
 	if err != nil {
 		return false, err
 	}
@@ -31,14 +38,19 @@ func (s *FriendshipService) CanInviteFriend(userID, friendID int) (bool, error)
 	return hasSharedRace, nil
 }
 
-func (s *FriendshipService) InviteFriend(userID, friendID int) error {
-	canInvite, err := s.CanInviteFriend(userID, friendID)
+// InviteFriend sends a friend request. It reports whether a new request was
+// created, as opposed to one already existing.
+func (s *FriendshipService) InviteFriend(ctx context.Context, userID, friendID int) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "FriendshipService.InviteFriend", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	canInvite, err := s.CanInviteFriend(ctx, userID, friendID)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if !canInvite {
-		return fmt.Errorf("you must participate in at least one race together before sending a friend request")
+		return false, fmt.Errorf("you must participate in at least one race together before sending a friend request")
 	}
 
 	query := `
@@ -46,12 +58,42 @@ func (s *FriendshipService) InviteFriend(userID, friendID int) error {
 		VALUES ($1, $2, 'pending')
 		ON CONFLICT (user_id, friend_id) DO NOTHING`
 	
-	_, err = s.db.Exec(query, userID, friendID)
-	return err
+	result, err := s.db.ExecContext(ctx, query, userID, friendID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
 }
 
-func (s *FriendshipService) AcceptFriendship(userID, friendID int) error {
-	tx, err := s.db.Begin()
+func (s *FriendshipService) AreFriends(ctx context.Context, userID, friendID int) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "FriendshipService.AreFriends", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	query := `
+		SELECT COUNT(*) > 0
+		FROM friendships
+		WHERE user_id = $1 AND friend_id = $2 AND status = 'accepted'`
+
+	var areFriends bool
+	err = s.db.QueryRowContext(ctx, query, userID, friendID).Scan(&areFriends)
+	if err != nil {
+		return false, err
+	}
+
+	return areFriends, nil
+}
+
+func (s *FriendshipService) AcceptFriendship(ctx context.Context, userID, friendID int) (err error) {
+	ctx, span := tracing.Start(ctx, "FriendshipService.AcceptFriendship", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -62,7 +104,7 @@ func (s *FriendshipService) AcceptFriendship(userID, friendID int) error {
 		SET status = 'accepted', accepted_at = CURRENT_TIMESTAMP
 		WHERE user_id = $1 AND friend_id = $2 AND status = 'pending'`
 	
-	result, err := tx.Exec(query, friendID, userID)
+	result, err := tx.ExecContext(ctx, query, friendID, userID)
 	if err != nil {
 		return err
 	}
@@ -83,7 +125,7 @@ func (s *FriendshipService) AcceptFriendship(userID, friendID int) error {
 			status = 'accepted',
 			accepted_at = CURRENT_TIMESTAMP`
 	
-	_, err = tx.Exec(reverseQuery, userID, friendID)
+	_, err = tx.ExecContext(ctx, reverseQuery, userID, friendID)
 	if err != nil {
 		return err
 	}
@@ -91,14 +133,17 @@ func (s *FriendshipService) AcceptFriendship(userID, friendID int) error {
 	return tx.Commit()
 }
 
-func (s *FriendshipService) GetFriends(userID int) ([]models.User, error) {
+func (s *FriendshipService) GetFriends(ctx context.Context, userID int) (_ []models.User, err error) {
+	ctx, span := tracing.Start(ctx, "FriendshipService.GetFriends", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
 	query := `
 		SELECT u.id, u.email, u.username, u.email_verified, u.created_at, u.updated_at
 		FROM users u
 		JOIN friendships f ON u.id = f.friend_id
 		WHERE f.user_id = $1 AND f.status = 'accepted'`
 	
-	rows, err := s.db.Query(query, userID)
+	rows, err := s.db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -120,13 +165,16 @@ func (s *FriendshipService) GetFriends(userID int) ([]models.User, error) {
 	return friends, nil
 }
 
-func (s *FriendshipService) GetPendingInvitations(userID int) ([]models.Friendship, error) {
+func (s *FriendshipService) GetPendingInvitations(ctx context.Context, userID int) (_ []models.Friendship, err error) {
+	ctx, span := tracing.Start(ctx, "FriendshipService.GetPendingInvitations", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
 	query := `
 		SELECT f.id, f.user_id, f.friend_id, f.status, f.created_at, f.accepted_at
 		FROM friendships f
 		WHERE f.friend_id = $1 AND f.status = 'pending'`
 	
-	rows, err := s.db.Query(query, userID)
+	rows, err := s.db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
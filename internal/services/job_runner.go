@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"ergracer-api/internal/models"
+	"ergracer-api/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// jobRunRetention is how long job_runs rows are kept.
+const jobRunRetention = 30 * 24 * time.Hour
+
+// Job is a housekeeping task run periodically by the JobRunner. Run returns
+// the number of rows it affected.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) (int64, error)
+}
+
+// JobRunner runs registered jobs on their intervals. Every replica runs a
+// JobRunner; a Postgres advisory lock per job and the job_runs history make
+// sure each job runs on only one replica per interval.
+type JobRunner struct {
+	db       *sql.DB
+	instance string
+	logger   *slog.Logger
+
+	mu   sync.Mutex
+	jobs []Job
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewJobRunner(db *sql.DB, logger *slog.Logger) *JobRunner {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "unknown"
+	}
+
+	return &JobRunner{
+		db:       db,
+		instance: fmt.Sprintf("%s/%d", instance, os.Getpid()),
+		logger:   logger,
+	}
+}
+
+// Register adds a job. Jobs must be registered before Start.
+func (r *JobRunner) Register(job Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs = append(r.jobs, job)
+}
+
+// Start runs every job on its interval until Stop is called. The first run of
+// each job happens after a random delay of up to a minute, so replicas
+// starting together do not all contend for the locks at once.
+func (r *JobRunner) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, job := range r.jobs {
+		r.wg.Add(1)
+		go r.loop(ctx, job)
+	}
+}
+
+// Stop cancels running jobs and waits for them to return.
+func (r *JobRunner) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	r.wg.Wait()
+}
+
+// Jobs lists the registered jobs with their most recent run.
+func (r *JobRunner) Jobs() ([]models.JobStatus, error) {
+	r.mu.Lock()
+	jobs := append([]Job(nil), r.jobs...)
+	r.mu.Unlock()
+
+	statuses := make([]models.JobStatus, 0, len(jobs))
+	for _, job := range jobs {
+		runs, err := r.GetJobRuns(job.Name, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		status := models.JobStatus{Name: job.Name, Interval: job.Interval.String()}
+		if len(runs) > 0 {
+			status.LastRun = &runs[0]
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// GetJobRuns returns the most recent runs of a job, newest first.
+func (r *JobRunner) GetJobRuns(jobName string, limit int) ([]models.JobRun, error) {
+	query := `
+		SELECT id, job_name, instance, status, affected_rows, error, started_at, finished_at
+		FROM job_runs
+		WHERE job_name = $1
+		ORDER BY started_at DESC
+		LIMIT $2`
+
+	rows, err := r.db.Query(query, jobName, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []models.JobRun{}
+	for rows.Next() {
+		var run models.JobRun
+		err := rows.Scan(
+			&run.ID, &run.JobName, &run.Instance, &run.Status, &run.AffectedRows,
+			&run.Error, &run.StartedAt, &run.FinishedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// HasJob reports whether a job with the given name is registered.
+func (r *JobRunner) HasJob(jobName string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, job := range r.jobs {
+		if job.Name == jobName {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *JobRunner) loop(ctx context.Context, job Job) {
+	defer r.wg.Done()
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(time.Minute))))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if err := r.runIfLeader(ctx, job); err != nil && ctx.Err() == nil {
+				r.logger.Error("job failed", "job", job.Name, "error", err)
+			}
+			timer.Reset(job.Interval)
+		}
+	}
+}
+
+// runIfLeader runs the job unless another replica holds its lock or ran it
+// within the last interval.
+func (r *JobRunner) runIfLeader(ctx context.Context, job Job) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	lockKey := "job:" + job.Name
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, lockKey).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	// Unlock with a fresh context so a cancelled run still releases the lock.
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, lockKey)
+
+	var ranRecently bool
+	err = conn.QueryRowContext(ctx, `
+		SELECT COUNT(*) > 0 FROM job_runs
+		WHERE job_name = $1 AND started_at > CURRENT_TIMESTAMP - make_interval(secs => $2)`,
+		job.Name, job.Interval.Seconds()*0.9,
+	).Scan(&ranRecently)
+	if err != nil {
+		return err
+	}
+	if ranRecently {
+		return nil
+	}
+
+	var runID int
+	err = conn.QueryRowContext(ctx,
+		`INSERT INTO job_runs (job_name, instance) VALUES ($1, $2) RETURNING id`,
+		job.Name, r.instance,
+	).Scan(&runID)
+	if err != nil {
+		return err
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, job.Interval)
+	runCtx, span := tracing.Start(runCtx, "job "+job.Name, attribute.String("job.instance", r.instance))
+	affected, runErr := job.Run(runCtx)
+	span.SetAttributes(attribute.Int64("job.affected_rows", affected))
+	tracing.End(span, &runErr)
+	cancel()
+
+	status := models.JobRunSucceeded
+	var errText *string
+	if runErr != nil {
+		status = models.JobRunFailed
+		text := runErr.Error()
+		errText = &text
+	}
+
+	_, err = conn.ExecContext(context.Background(), `
+		UPDATE job_runs
+		SET status = $2, affected_rows = $3, error = $4, finished_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		runID, status, affected, errText,
+	)
+	if err != nil {
+		return err
+	}
+	r.logger.Info("job finished", "job", job.Name, "status", status, "affected_rows", affected)
+
+	_, err = conn.ExecContext(context.Background(),
+		`DELETE FROM job_runs WHERE job_name = $1 AND started_at < CURRENT_TIMESTAMP - make_interval(secs => $2)`,
+		job.Name, jobRunRetention.Seconds(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return runErr
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"sync"
+
+	"ergracer-api/internal/models"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it
+// is dropped. Dropped subscribers see their channel closed and are expected to
+// reconnect and start again from a fresh snapshot.
+const subscriberBuffer = 64
+
+// RaceBroker fans race events out to everyone watching a race.
+type RaceBroker struct {
+	mu          sync.Mutex
+	subscribers map[int]map[chan models.RaceEvent]struct{}
+	closed      bool
+	active      sync.WaitGroup
+}
+
+func NewRaceBroker() *RaceBroker {
+	return &RaceBroker{
+		subscribers: make(map[int]map[chan models.RaceEvent]struct{}),
+	}
+}
+
+// Subscribe registers interest in a race. The returned function must be
+// called once the caller stops reading from the channel.
+// Once the broker is closed the channel is returned already closed.
+func (b *RaceBroker) Subscribe(raceID int) (<-chan models.RaceEvent, func()) {
+	ch := make(chan models.RaceEvent, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subscribers[raceID] == nil {
+		b.subscribers[raceID] = make(map[chan models.RaceEvent]struct{})
+	}
+	b.subscribers[raceID][ch] = struct{}{}
+	b.active.Add(1)
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			b.remove(raceID, ch)
+			b.mu.Unlock()
+			b.active.Done()
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+// Close ends every subscription, telling subscribers the server is going
+// away, and refuses new ones.
+func (b *RaceBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for raceID, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			b.remove(raceID, ch)
+		}
+	}
+}
+
+// Closed reports whether Close has been called. Subscribers use it to tell a
+// shutdown apart from being dropped for falling behind.
+func (b *RaceBroker) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+// Wait blocks until every subscriber has unsubscribed.
+func (b *RaceBroker) Wait() {
+	b.active.Wait()
+}
+
+func (b *RaceBroker) Publish(event models.RaceEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[event.RaceID] {
+		select {
+		case ch <- event:
+		default:
+			b.remove(event.RaceID, ch)
+		}
+	}
+}
+
+// remove must be called with b.mu held.
+func (b *RaceBroker) remove(raceID int, ch chan models.RaceEvent) {
+	subscribers, ok := b.subscribers[raceID]
+	if !ok {
+		return
+	}
+
+	if _, ok := subscribers[ch]; !ok {
+		return
+	}
+
+	delete(subscribers, ch)
+	close(ch)
+
+	if len(subscribers) == 0 {
+		delete(b.subscribers, raceID)
+	}
+}
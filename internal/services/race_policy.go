@@ -0,0 +1,65 @@
+package services
+
+import (
+	"errors"
+
+	"ergracer-api/internal/models"
+)
+
+// Errors returned when a user may not act on a race.
+var (
+	ErrRaceNotFound       = errors.New("race not found")
+	ErrNotRaceParticipant = errors.New("not a participant of this race")
+	ErrNotRaceCreator     = errors.New("only the race creator can do this")
+)
+
+// RaceAction is something a user asks to do to a race.
+type RaceAction int
+
+const (
+	// RaceActionParticipate covers readying up and reporting progress.
+	RaceActionParticipate RaceAction = iota
+	// RaceActionManage covers starting the race.
+	RaceActionManage
+)
+
+func (a RaceAction) String() string {
+	switch a {
+	case RaceActionParticipate:
+		return "participate"
+	case RaceActionManage:
+		return "manage"
+	default:
+		return "unknown"
+	}
+}
+
+// RaceAccess is what is known about a user's relation to a race when
+// deciding what they may do to it.
+type RaceAccess struct {
+	Race        *models.Race
+	Participant bool
+}
+
+// Authorize reports whether userID may perform action on the race. Only
+// participants may participate, and only the creator may manage the race.
+func (a RaceAccess) Authorize(userID int, action RaceAction) error {
+	if a.Race == nil {
+		return ErrRaceNotFound
+	}
+
+	switch action {
+	case RaceActionParticipate:
+		if !a.Participant {
+			return ErrNotRaceParticipant
+		}
+	case RaceActionManage:
+		if a.Race.CreatedBy != userID {
+			return ErrNotRaceCreator
+		}
+	default:
+		return errors.New("unknown race action")
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"ergracer-api/internal/models"
+)
+
+func TestRaceAccessAuthorize(t *testing.T) {
+	const creator, participant, outsider = 1, 2, 3
+	race := &models.Race{ID: 10, CreatedBy: creator, Status: "waiting"}
+
+	tests := []struct {
+		name   string
+		access RaceAccess
+		userID int
+		action RaceAction
+		want   error
+	}{
+		{"missing race", RaceAccess{}, participant, RaceActionParticipate, ErrRaceNotFound},
+		{"participant participates", RaceAccess{Race: race, Participant: true}, participant, RaceActionParticipate, nil},
+		{"creator participates", RaceAccess{Race: race, Participant: true}, creator, RaceActionParticipate, nil},
+		{"outsider participates", RaceAccess{Race: race}, outsider, RaceActionParticipate, ErrNotRaceParticipant},
+		{"creator manages", RaceAccess{Race: race, Participant: true}, creator, RaceActionManage, nil},
+		{"participant manages", RaceAccess{Race: race, Participant: true}, participant, RaceActionManage, ErrNotRaceCreator},
+		{"outsider manages", RaceAccess{Race: race}, outsider, RaceActionManage, ErrNotRaceCreator},
+		{"missing race manages", RaceAccess{}, creator, RaceActionManage, ErrRaceNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.access.Authorize(tt.userID, tt.action)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Authorize(%d, %s) = %v, want %v", tt.userID, tt.action, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRaceAccessAuthorizeUnknownAction(t *testing.T) {
+	access := RaceAccess{Race: &models.Race{CreatedBy: 1}, Participant: true}
+	if err := access.Authorize(1, RaceAction(99)); err == nil {
+		t.Error("Authorize with an unknown action succeeded, want an error")
+	}
+}
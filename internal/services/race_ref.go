@@ -0,0 +1,83 @@
+package services
+
+import (
+	"crypto/rand"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// JoinCodeLength is the length of the code rowers type to join a race.
+const JoinCodeLength = 6
+
+// maxJoinCodeAttempts bounds how often CreateRace draws a new join code after
+// a collision.
+const maxJoinCodeAttempts = 5
+
+// joinCodeAlphabet leaves out characters that are easily confused on an erg
+// screen (0/O, 1/I). It has 32 characters, so a random byte maps onto it
+// without bias.
+const joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// RaceRef identifies a race by one of its UUID, its join code or, on routes
+// that still accept it, its numeric ID.
+type RaceRef struct {
+	UUID     string
+	JoinCode string
+	ID       int
+}
+
+// where returns the condition on races selecting the referenced race and its
+// argument.
+func (r RaceRef) where() (string, interface{}) {
+	switch {
+	case r.UUID != "":
+		return "uuid = $1", canonicalRaceUUID(r.UUID)
+	case r.JoinCode != "":
+		return "join_code = $1", NormalizeJoinCode(r.JoinCode)
+	default:
+		return "id = $1", r.ID
+	}
+}
+
+// NormalizeRaceUUID returns a race UUID in the lower-case form races.uuid
+// holds, and false if s is not a UUID.
+func NormalizeRaceUUID(s string) (string, bool) {
+	parsed, err := uuid.Parse(s)
+	if err != nil {
+		return "", false
+	}
+	return parsed.String(), true
+}
+
+// canonicalRaceUUID is NormalizeRaceUUID for lookups: a string that is not a
+// UUID is returned unchanged and simply matches no race.
+func canonicalRaceUUID(s string) string {
+	if canonical, ok := NormalizeRaceUUID(s); ok {
+		return canonical
+	}
+	return s
+}
+
+// NormalizeJoinCode upper-cases a join code and drops the spaces and dashes
+// people type to group its characters.
+func NormalizeJoinCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
+
+// newJoinCode returns a random join code.
+func newJoinCode() (string, error) {
+	b := make([]byte, JoinCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = joinCodeAlphabet[int(b[i])%len(joinCodeAlphabet)]
+	}
+	return string(b), nil
+}
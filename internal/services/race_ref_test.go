@@ -0,0 +1,69 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewJoinCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := newJoinCode()
+		if err != nil {
+			t.Fatalf("newJoinCode() error = %v", err)
+		}
+		if len(code) != JoinCodeLength {
+			t.Fatalf("newJoinCode() = %q, want %d characters", code, JoinCodeLength)
+		}
+		if strings.Trim(code, joinCodeAlphabet) != "" {
+			t.Fatalf("newJoinCode() = %q, want only characters of %q", code, joinCodeAlphabet)
+		}
+	}
+}
+
+func TestRaceRefWhere(t *testing.T) {
+	tests := []struct {
+		name      string
+		ref       RaceRef
+		wantWhere string
+		wantArg   interface{}
+	}{
+		{"uuid", RaceRef{UUID: "3f2b8c1e-5d4a-4e6f-9a7b-1c2d3e4f5a6b"}, "uuid = $1", "3f2b8c1e-5d4a-4e6f-9a7b-1c2d3e4f5a6b"},
+		{"upper-case uuid", RaceRef{UUID: "3F2B8C1E-5D4A-4E6F-9A7B-1C2D3E4F5A6B"}, "uuid = $1", "3f2b8c1e-5d4a-4e6f-9a7b-1c2d3e4f5a6b"},
+		{"braced uuid", RaceRef{UUID: "{3f2b8c1e-5d4a-4e6f-9a7b-1c2d3e4f5a6b}"}, "uuid = $1", "3f2b8c1e-5d4a-4e6f-9a7b-1c2d3e4f5a6b"},
+		{"malformed uuid", RaceRef{UUID: "not-a-uuid"}, "uuid = $1", "not-a-uuid"},
+		{"join code", RaceRef{JoinCode: "K7M2QX"}, "join_code = $1", "K7M2QX"},
+		{"typed join code", RaceRef{JoinCode: "k7m 2qx"}, "join_code = $1", "K7M2QX"},
+		{"dashed join code", RaceRef{JoinCode: "K7M-2QX"}, "join_code = $1", "K7M2QX"},
+		{"numeric ID", RaceRef{ID: 7}, "id = $1", 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, arg := tt.ref.where()
+			if where != tt.wantWhere || arg != tt.wantArg {
+				t.Errorf("where() = %q, %v, want %q, %v", where, arg, tt.wantWhere, tt.wantArg)
+			}
+		})
+	}
+}
+
+func TestNormalizeRaceUUID(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   string
+		wantOK bool
+	}{
+		{"3f2b8c1e-5d4a-4e6f-9a7b-1c2d3e4f5a6b", "3f2b8c1e-5d4a-4e6f-9a7b-1c2d3e4f5a6b", true},
+		{"3F2B8C1E-5D4A-4E6F-9A7B-1C2D3E4F5A6B", "3f2b8c1e-5d4a-4e6f-9a7b-1c2d3e4f5a6b", true},
+		{"urn:uuid:3f2b8c1e-5d4a-4e6f-9a7b-1c2d3e4f5a6b", "3f2b8c1e-5d4a-4e6f-9a7b-1c2d3e4f5a6b", true},
+		{"3f2b8c1e", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := NormalizeRaceUUID(tt.in)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("NormalizeRaceUUID(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
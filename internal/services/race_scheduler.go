@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"ergracer-api/internal/logging"
+	"ergracer-api/internal/metrics"
+	"ergracer-api/internal/models"
+)
+
+// resyncInterval controls how often the scheduler re-scans the races table
+// for countdowns it does not know about yet, e.g. ones written by another
+// replica or ones whose timer was lost.
+const resyncInterval = 15 * time.Second
+
+// RaceScheduler moves races from countdown to active once their countdown_at
+// time has passed. Every countdown race gets its own timer; the races table is
+// the source of truth, so the scheduler re-scans it on start and periodically
+// afterwards.
+type RaceScheduler struct {
+	raceService *RaceService
+	logger      *slog.Logger
+
+	mu      sync.Mutex
+	timers  map[int]*time.Timer
+	stop    chan struct{}
+	stopped bool
+}
+
+func NewRaceScheduler(raceService *RaceService, logger *slog.Logger) *RaceScheduler {
+	return &RaceScheduler{
+		raceService: raceService,
+		logger:      logger,
+		timers:      make(map[int]*time.Timer),
+		stop:        make(chan struct{}),
+	}
+}
+
+// Start schedules every race currently in countdown and begins the periodic
+// re-scan. Races whose countdown already elapsed while the server was down are
+// started immediately.
+func (s *RaceScheduler) Start() error {
+	if err := s.resync(); err != nil {
+		return err
+	}
+
+	go s.run()
+	return nil
+}
+
+// Stop cancels all pending timers. Races left in countdown are picked up
+// again by the next Start.
+func (s *RaceScheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	close(s.stop)
+
+	for raceID, timer := range s.timers {
+		timer.Stop()
+		delete(s.timers, raceID)
+	}
+}
+
+// Schedule arranges for the race to be started at the given time, replacing
+// any timer already registered for it.
+func (s *RaceScheduler) Schedule(raceID int, startAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return
+	}
+
+	if timer, ok := s.timers[raceID]; ok {
+		timer.Stop()
+	}
+
+	s.timers[raceID] = time.AfterFunc(time.Until(startAt), func() {
+		s.fire(raceID)
+	})
+}
+
+func (s *RaceScheduler) fire(raceID int) {
+	s.mu.Lock()
+	delete(s.timers, raceID)
+	s.mu.Unlock()
+
+	ctx := logging.WithLogger(context.Background(), s.logger)
+	err := s.raceService.StartRace(ctx, raceID)
+	if errors.Is(err, models.ErrIllegalTransition) {
+		// Started early by its creator or finished by an operator.
+		s.logger.Debug("race left countdown before it elapsed", "race_id", raceID, "error", err)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to start race after countdown", "race_id", raceID, "error", err)
+		metrics.CountdownFailures.Inc()
+	}
+}
+
+func (s *RaceScheduler) run() {
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.resync(); err != nil {
+				s.logger.Error("failed to rescan countdown races", "error", err)
+			}
+		}
+	}
+}
+
+func (s *RaceScheduler) resync() error {
+	races, err := s.raceService.GetCountdownRaces(context.Background())
+	if err != nil {
+		return err
+	}
+
+	for _, race := range races {
+		s.mu.Lock()
+		_, scheduled := s.timers[race.ID]
+		s.mu.Unlock()
+
+		if !scheduled && race.CountdownAt != nil {
+			s.Schedule(race.ID, *race.CountdownAt)
+		}
+	}
+
+	return nil
+}
@@ -1,40 +1,112 @@
 package services
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"errors"
+	"log/slog"
 	"time"
 
+	"ergracer-api/internal/logging"
+	"ergracer-api/internal/metrics"
 	"ergracer-api/internal/models"
+	"ergracer-api/internal/tracing"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var ErrRaceAlreadyFinished = errors.New("race is already finished")
+
 type RaceService struct {
-	db *sql.DB
+	db           *sql.DB
+	broker       *RaceBroker
+	emailService *EmailService
+	logger       *slog.Logger
+}
+
+func NewRaceService(db *sql.DB, broker *RaceBroker, emailService *EmailService, logger *slog.Logger) *RaceService {
+	return &RaceService{db: db, broker: broker, emailService: emailService, logger: logger}
+}
+
+// log returns the logger for a race, preferring the request-scoped logger
+// carried by ctx so race events can be traced back to the request.
+func (s *RaceService) log(ctx context.Context, raceID int) *slog.Logger {
+	return logging.FromContextOr(ctx, s.logger).With("race_id", raceID)
 }
 
-func NewRaceService(db *sql.DB) *RaceService {
-	return &RaceService{db: db}
+// Subscribe streams the live events of a race. The returned function must be
+// called once the caller is done with the channel.
+func (s *RaceService) Subscribe(raceID int) (<-chan models.RaceEvent, func()) {
+	return s.broker.Subscribe(raceID)
 }
 
-func (s *RaceService) CreateRace(userID, distance int) (*models.Race, error) {
+// ShuttingDown reports whether live event streams are being closed because the
+// server is stopping.
+func (s *RaceService) ShuttingDown() bool {
+	return s.broker.Closed()
+}
+
+func (s *RaceService) publish(eventType string, raceID, userID int, data interface{}) {
+	s.broker.Publish(models.RaceEvent{
+		Type:      eventType,
+		RaceID:    raceID,
+		UserID:    userID,
+		Data:      data,
+		Timestamp: time.Now(),
+	})
+}
+
+func (s *RaceService) CreateRace(ctx context.Context, userID, distance int) (_ *models.Race, err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.CreateRace")
+	defer tracing.End(span, &err)
+
 	raceUUID := uuid.New().String()
 
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	var race models.Race
 	query := `
-		INSERT INTO races (uuid, distance, created_by)
-		VALUES ($1, $2, $3)
-		RETURNING id, uuid, distance, status, created_by, created_at`
-	
-	err := s.db.QueryRow(query, raceUUID, distance, userID).Scan(
-		&race.ID, &race.UUID, &race.Distance, &race.Status, &race.CreatedBy, &race.CreatedAt,
-	)
-	if err != nil {
+		INSERT INTO races (uuid, join_code, distance, created_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (join_code) DO NOTHING
+		RETURNING id, uuid, join_code, distance, status, created_by, created_at`
+
+	// Join codes are short enough to collide now and then; draw another one
+	// when they do.
+	for attempt := 0; ; attempt++ {
+		joinCode, err := newJoinCode()
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.QueryRowContext(ctx, query, raceUUID, joinCode, distance, userID).Scan(
+			&race.ID, &race.UUID, &race.JoinCode, &race.Distance, &race.Status, &race.CreatedBy, &race.CreatedAt,
+		)
+		if err == nil {
+			break
+		}
+		if err != sql.ErrNoRows || attempt == maxJoinCodeAttempts-1 {
+			return nil, err
+		}
+	}
+
+	span.SetAttributes(attribute.Int("race.id", race.ID))
+
+	if err := recordTransition(ctx, tx, race.ID, 0, models.TimelineRaceCreated, "race", "", string(race.Status),
+		map[string]interface{}{"distance": race.Distance}); err != nil {
+		return nil, err
+	}
+
+	if err := transitionParticipant(ctx, tx, race.ID, userID, models.ParticipantNone, models.ParticipantNotReady, race.Status, models.TimelineJoined, nil); err != nil {
 		return nil, err
 	}
 
-	_, err = s.db.Exec(
+	_, err = tx.ExecContext(ctx,
 		"INSERT INTO race_participants (race_id, user_id) VALUES ($1, $2)",
 		race.ID, userID,
 	)
@@ -42,62 +114,192 @@ func (s *RaceService) CreateRace(userID, distance int) (*models.Race, error) {
 		return nil, err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.log(ctx, race.ID).Info("race created", "race_uuid", race.UUID, "distance", distance, "created_by", userID)
+	metrics.RacesCreated.Inc()
 	return &race, nil
 }
 
-func (s *RaceService) JoinRace(raceUUID string, userID int) error {
-	var raceID int
-	err := s.db.QueryRow("SELECT id FROM races WHERE uuid = $1 AND status = 'waiting'", raceUUID).Scan(&raceID)
+// JoinRace adds userID to the race identified by its UUID or join code and
+// returns the race. Joining a race twice is not an error.
+func (s *RaceService) JoinRace(ctx context.Context, ref RaceRef, userID int) (_ *models.Race, err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.JoinRace")
+	defer tracing.End(span, &err)
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("race not found or already started")
-		}
-		return err
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var race models.Race
+	where, arg := ref.where()
+	query := `
+		SELECT id, uuid, join_code, distance, status, created_by, created_at, started_at, finished_at, countdown_at
+		FROM races WHERE ` + where + ` FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, arg).Scan(
+		&race.ID, &race.UUID, &race.JoinCode, &race.Distance, &race.Status, &race.CreatedBy,
+		&race.CreatedAt, &race.StartedAt, &race.FinishedAt, &race.CountdownAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrRaceNotFound
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = s.db.Exec(
-		"INSERT INTO race_participants (race_id, user_id) VALUES ($1, $2) ON CONFLICT (race_id, user_id) DO NOTHING",
-		raceID, userID,
+	span.SetAttributes(attribute.Int("race.id", race.ID))
+
+	status, err := lockParticipant(ctx, tx, race.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if status != models.ParticipantNone {
+		return &race, nil
+	}
+
+	if err := transitionParticipant(ctx, tx, race.ID, userID, status, models.ParticipantNotReady, race.Status, models.TimelineJoined, nil); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO race_participants (race_id, user_id) VALUES ($1, $2)",
+		race.ID, userID,
 	)
-	return err
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.log(ctx, race.ID).Info("participant joined", "race_uuid", race.UUID, "participant_id", userID)
+	s.publish(models.RaceEventParticipantJoined, race.ID, userID, nil)
+	return &race, nil
 }
 
-func (s *RaceService) SetReadyStatus(raceID, userID int, ready bool) error {
-	status := "not_ready"
+func (s *RaceService) SetReadyStatus(ctx context.Context, raceID, userID int, ready bool) (err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.SetReadyStatus", attribute.Int("race.id", raceID))
+	defer tracing.End(span, &err)
+
+	next, eventType := models.ParticipantNotReady, models.TimelineUnreadied
 	if ready {
-		status = "ready"
+		next, eventType = models.ParticipantReady, models.TimelineReadied
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	raceStatus, err := lockRace(ctx, tx, raceID)
+	if err != nil {
+		return err
 	}
 
-	_, err := s.db.Exec(
+	status, err := lockParticipant(ctx, tx, raceID, userID)
+	if err != nil {
+		return err
+	}
+	if status == models.ParticipantNone {
+		return ErrNotRaceParticipant
+	}
+	if status == next && raceStatus == models.RaceWaiting {
+		return nil
+	}
+
+	if err := transitionParticipant(ctx, tx, raceID, userID, status, next, raceStatus, eventType, nil); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
 		"UPDATE race_participants SET status = $1 WHERE race_id = $2 AND user_id = $3",
-		status, raceID, userID,
+		next, raceID, userID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.log(ctx, raceID).Info("ready status changed", "participant_id", userID, "ready", ready)
+	s.publish(models.RaceEventReadyChanged, raceID, userID, map[string]interface{}{"ready": ready})
+	return nil
 }
 
-func (s *RaceService) GetRaceByUUID(raceUUID string) (*models.Race, error) {
+func (s *RaceService) GetRaceByUUID(ctx context.Context, raceUUID string) (_ *models.Race, err error) {
+	raceUUID = canonicalRaceUUID(raceUUID)
+	ctx, span := tracing.Start(ctx, "RaceService.GetRaceByUUID", attribute.String("race.uuid", raceUUID))
+	defer tracing.End(span, &err)
+
 	var race models.Race
 	query := `
-		SELECT id, uuid, distance, status, created_by, created_at, started_at, finished_at, countdown_at
+		SELECT id, uuid, join_code, distance, status, created_by, created_at, started_at, finished_at, countdown_at
 		FROM races WHERE uuid = $1`
 	
-	err := s.db.QueryRow(query, raceUUID).Scan(
-		&race.ID, &race.UUID, &race.Distance, &race.Status, &race.CreatedBy,
+	err = s.db.QueryRowContext(ctx, query, raceUUID).Scan(
+		&race.ID, &race.UUID, &race.JoinCode, &race.Distance, &race.Status, &race.CreatedBy,
+		&race.CreatedAt, &race.StartedAt, &race.FinishedAt, &race.CountdownAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &race, nil
+}
+
+// AuthorizeRace loads a race and checks that userID may perform action on it.
+// It returns ErrRaceNotFound if the race does not exist, or the error of the
+// rule the user fails.
+func (s *RaceService) AuthorizeRace(ctx context.Context, ref RaceRef, userID int, action RaceAction) (_ *models.Race, err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.AuthorizeRace", attribute.String("race.action", action.String()))
+	defer tracing.End(span, &err)
+
+	var race models.Race
+	var access RaceAccess
+	where, arg := ref.where()
+	query := `
+		SELECT id, uuid, join_code, distance, status, created_by, created_at, started_at, finished_at, countdown_at,
+			EXISTS (SELECT 1 FROM race_participants WHERE race_id = races.id AND user_id = $2)
+		FROM races WHERE ` + where
+
+	err = s.db.QueryRowContext(ctx, query, arg, userID).Scan(
+		&race.ID, &race.UUID, &race.JoinCode, &race.Distance, &race.Status, &race.CreatedBy,
 		&race.CreatedAt, &race.StartedAt, &race.FinishedAt, &race.CountdownAt,
+		&access.Participant,
 	)
+	if err == sql.ErrNoRows {
+		return nil, ErrRaceNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
+	access.Race = &race
+	span.SetAttributes(attribute.Int("race.id", race.ID))
 
+	if err := access.Authorize(userID, action); err != nil {
+		return nil, err
+	}
 	return &race, nil
 }
 
-func (s *RaceService) GetRaceParticipants(raceID int) ([]models.RaceParticipant, error) {
+func (s *RaceService) GetRaceParticipants(ctx context.Context, raceID int) (_ []models.RaceParticipant, err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.GetRaceParticipants", attribute.Int("race.id", raceID))
+	defer tracing.End(span, &err)
+
 	query := `
 		SELECT id, race_id, user_id, status, current_distance, finished_at, pace, position, joined_at
 		FROM race_participants WHERE race_id = $1`
 	
-	rows, err := s.db.Query(query, raceID)
+	rows, err := s.db.QueryContext(ctx, query, raceID)
 	if err != nil {
 		return nil, err
 	}
@@ -119,141 +321,506 @@ func (s *RaceService) GetRaceParticipants(raceID int) ([]models.RaceParticipant,
 	return participants, nil
 }
 
-func (s *RaceService) CheckAndStartCountdown(raceID int) error {
+// GetRaceUpdatesSince returns the progress updates of a race logged after the
+// given race_updates ID, oldest first.
+func (s *RaceService) GetRaceUpdatesSince(ctx context.Context, raceID, afterID int) (_ []models.RaceUpdate, err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.GetRaceUpdatesSince", attribute.Int("race.id", raceID))
+	defer tracing.End(span, &err)
+
+	query := `
+		SELECT id, race_id, user_id, distance, timestamp
+		FROM race_updates WHERE race_id = $1 AND id > $2
+		ORDER BY id`
+	
+	rows, err := s.db.QueryContext(ctx, query, raceID, afterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var updates []models.RaceUpdate
+	for rows.Next() {
+		var u models.RaceUpdate
+		err := rows.Scan(&u.ID, &u.RaceID, &u.UserID, &u.Distance, &u.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, u)
+	}
+
+	return updates, nil
+}
+
+// GetRaceTimeline returns everything that happened to a race and its
+// participants, oldest first.
+func (s *RaceService) GetRaceTimeline(ctx context.Context, raceID int) (_ []models.RaceTimelineEvent, err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.GetRaceTimeline", attribute.Int("race.id", raceID))
+	defer tracing.End(span, &err)
+
+	query := `
+		SELECT e.id, e.type, e.user_id, u.username, e.from_status, e.to_status, e.data, e.created_at
+		FROM race_events e
+		LEFT JOIN users u ON u.id = e.user_id
+		WHERE e.race_id = $1
+		ORDER BY e.id`
+
+	rows, err := s.db.QueryContext(ctx, query, raceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.RaceTimelineEvent{}
+	for rows.Next() {
+		var e models.RaceTimelineEvent
+		var data []byte
+		err := rows.Scan(&e.ID, &e.Type, &e.UserID, &e.Username, &e.FromStatus, &e.ToStatus, &data, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			e.Data = data
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// CheckAndStartCountdown puts a waiting race into countdown once every
+// participant is ready. It returns the time the race should start, or nil if
+// the countdown was not started.
+func (s *RaceService) CheckAndStartCountdown(ctx context.Context, raceID int) (_ *time.Time, err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.CheckAndStartCountdown", attribute.Int("race.id", raceID))
+	defer tracing.End(span, &err)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	raceStatus, err := lockRace(ctx, tx, raceID)
+	if err != nil {
+		return nil, err
+	}
+	if raceStatus != models.RaceWaiting {
+		return nil, nil
+	}
+
 	var totalParticipants, readyParticipants int
+	err = tx.QueryRowContext(ctx,
+		"SELECT COUNT(*), COUNT(*) FILTER (WHERE status = $2) FROM race_participants WHERE race_id = $1",
+		raceID, models.ParticipantReady,
+	).Scan(&totalParticipants, &readyParticipants)
+	if err != nil {
+		return nil, err
+	}
+
+	if totalParticipants < 2 || totalParticipants != readyParticipants {
+		return nil, nil
+	}
+
+	countdownTime := time.Now().Add(10 * time.Second)
+	err = transitionRace(ctx, tx, raceID, raceStatus, models.RaceCountdown, models.TimelineCountdownStarted,
+		map[string]interface{}{"countdown_at": countdownTime, "participants": totalParticipants})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE races SET status = $1, countdown_at = $2 WHERE id = $3",
+		models.RaceCountdown, countdownTime, raceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.log(ctx, raceID).Info("countdown started", "countdown_at", countdownTime, "participants", totalParticipants)
+	s.publish(models.RaceEventCountdownStarted, raceID, 0, map[string]interface{}{"countdown_at": countdownTime})
+	return &countdownTime, nil
+}
+
+func (s *RaceService) GetCountdownRaces(ctx context.Context) (_ []models.Race, err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.GetCountdownRaces")
+	defer tracing.End(span, &err)
+
+	query := `
+		SELECT id, uuid, join_code, distance, status, created_by, created_at, started_at, finished_at, countdown_at
+		FROM races WHERE status = 'countdown'`
 	
-	err := s.db.QueryRow(
-		"SELECT COUNT(*) FROM race_participants WHERE race_id = $1",
-		raceID,
-	).Scan(&totalParticipants)
+	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	err = s.db.QueryRow(
-		"SELECT COUNT(*) FROM race_participants WHERE race_id = $1 AND status = 'ready'",
-		raceID,
-	).Scan(&readyParticipants)
+	var races []models.Race
+	for rows.Next() {
+		var race models.Race
+		err := rows.Scan(
+			&race.ID, &race.UUID, &race.JoinCode, &race.Distance, &race.Status, &race.CreatedBy,
+			&race.CreatedAt, &race.StartedAt, &race.FinishedAt, &race.CountdownAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		races = append(races, race)
+	}
+
+	return races, nil
+}
+
+func (s *RaceService) StartRace(ctx context.Context, raceID int) (err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.StartRace", attribute.Int("race.id", raceID))
+	defer tracing.End(span, &err)
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	if totalParticipants > 1 && totalParticipants == readyParticipants {
-		countdownTime := time.Now().Add(10 * time.Second)
-		_, err = s.db.Exec(
-			"UPDATE races SET status = 'countdown', countdown_at = $1 WHERE id = $2",
-			countdownTime, raceID,
-		)
+	raceStatus, err := lockRace(ctx, tx, raceID)
+	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	if err := transitionRace(ctx, tx, raceID, raceStatus, models.RaceActive, models.TimelineRaceStarted, nil); err != nil {
+		return err
+	}
 
-func (s *RaceService) StartRace(raceID int) error {
 	now := time.Now()
-	_, err := s.db.Exec(
-		"UPDATE races SET status = 'active', started_at = $1 WHERE id = $2 AND status = 'countdown'",
-		now, raceID,
+	_, err = tx.ExecContext(ctx,
+		"UPDATE races SET status = $1, started_at = $2 WHERE id = $3",
+		models.RaceActive, now, raceID,
 	)
 	if err != nil {
 		return err
 	}
 
-	_, err = s.db.Exec(
-		"UPDATE race_participants SET status = 'racing' WHERE race_id = $1 AND status = 'ready'",
+	rows, err := tx.QueryContext(ctx,
+		"SELECT user_id, status FROM race_participants WHERE race_id = $1 ORDER BY user_id FOR UPDATE",
 		raceID,
 	)
-	return err
-}
-
-func (s *RaceService) UpdateRaceProgress(raceID, userID, distance int) error {
-	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	statuses := make(map[int]models.ParticipantStatus)
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		var status models.ParticipantStatus
+		if err := rows.Scan(&userID, &status); err != nil {
+			rows.Close()
+			return err
+		}
+		statuses[userID] = status
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	_, err = tx.Exec(
-		"INSERT INTO race_updates (race_id, user_id, distance) VALUES ($1, $2, $3)",
-		raceID, userID, distance,
+	// Everyone was ready for the countdown to start, so every participant
+	// starts racing.
+	for _, userID := range userIDs {
+		err := transitionParticipant(ctx, tx, raceID, userID, statuses[userID], models.ParticipantRacing, models.RaceActive, models.TimelineStartedRacing, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE race_participants SET status = $1 WHERE race_id = $2",
+		models.ParticipantRacing, raceID,
 	)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec(
-		"UPDATE race_participants SET current_distance = $1 WHERE race_id = $2 AND user_id = $3",
-		distance, raceID, userID,
-	)
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.log(ctx, raceID).Info("race started", "started_at", now)
+	metrics.RacesStarted.Inc()
+	s.publish(models.RaceEventRaceStarted, raceID, 0, map[string]interface{}{"started_at": now})
+	return nil
+}
+
+func (s *RaceService) UpdateRaceProgress(ctx context.Context, raceID, userID, distance int) (err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.UpdateRaceProgress",
+		attribute.Int("race.id", raceID), attribute.Int("race.distance", distance))
+	defer tracing.End(span, &err)
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
+	var raceStatus models.RaceStatus
 	var raceDistance int
-	err = tx.QueryRow("SELECT distance FROM races WHERE id = $1", raceID).Scan(&raceDistance)
+	err = tx.QueryRowContext(ctx,
+		"SELECT status, distance FROM races WHERE id = $1 FOR UPDATE",
+		raceID,
+	).Scan(&raceStatus, &raceDistance)
+	if err == sql.ErrNoRows {
+		return ErrRaceNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// Only participants have a row to update; nothing is logged for anyone
+	// else.
+	status, err := lockParticipant(ctx, tx, raceID, userID)
 	if err != nil {
 		return err
 	}
+	if status == models.ParticipantNone {
+		return ErrNotRaceParticipant
+	}
 
+	next := models.ParticipantRacing
 	if distance >= raceDistance {
+		next = models.ParticipantFinished
+	}
+	if err := status.TransitionTo(next, raceStatus); err != nil {
+		return err
+	}
+
+	var finishedAt *time.Time
+	if next == models.ParticipantFinished {
 		now := time.Now()
-		_, err = tx.Exec(
-			"UPDATE race_participants SET status = 'finished', finished_at = $1 WHERE race_id = $2 AND user_id = $3",
-			now, raceID, userID,
-		)
+		finishedAt = &now
+		err := transitionParticipant(ctx, tx, raceID, userID, status, next, raceStatus, models.TimelineFinished,
+			map[string]interface{}{"distance": distance})
 		if err != nil {
 			return err
 		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE race_participants SET current_distance = $1, status = $2, finished_at = $3 WHERE race_id = $4 AND user_id = $5",
+		distance, next, finishedAt, raceID, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	var updateID int
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO race_updates (race_id, user_id, distance) VALUES ($1, $2, $3) RETURNING id",
+		raceID, userID, distance,
+	).Scan(&updateID)
+	if err != nil {
+		return err
+	}
 
-		err = s.checkRaceCompletion(tx, raceID)
+	raceFinished := false
+	if finishedAt != nil {
+		raceFinished, err = s.checkRaceCompletion(ctx, tx, raceID)
 		if err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.broker.Publish(models.RaceEvent{
+		ID:        updateID,
+		Type:      models.RaceEventProgress,
+		RaceID:    raceID,
+		UserID:    userID,
+		Data:      map[string]interface{}{"distance": distance},
+		Timestamp: time.Now(),
+	})
+
+	logger := s.log(ctx, raceID)
+	logger.Debug("progress updated", "participant_id", userID, "distance", distance)
+	metrics.ProgressUpdates.Inc()
+
+	if finishedAt != nil {
+		logger.Info("participant finished", "participant_id", userID, "finished_at", *finishedAt)
+		s.publish(models.RaceEventParticipantFinished, raceID, userID, map[string]interface{}{"finished_at": *finishedAt})
+	}
+
+	if raceFinished {
+		logger.Info("race finished")
+		metrics.RacesFinished.Inc()
+		s.publishResults(ctx, raceID)
+
+		s.emailService.SendInBackground(ctx, "race results", func(ctx context.Context) error {
+			return s.emailService.SendRaceResults(ctx, raceID)
+		})
+	}
+
+	return nil
 }
 
-func (s *RaceService) checkRaceCompletion(tx *sql.Tx, raceID int) error {
-	var totalParticipants, finishedParticipants int
-	
-	err := tx.QueryRow(
-		"SELECT COUNT(*) FROM race_participants WHERE race_id = $1",
-		raceID,
-	).Scan(&totalParticipants)
+// ForceFinishRace finishes a race that cannot finish on its own, e.g. because
+// a participant left. Participants who finished are ranked as usual; the
+// others keep their status and get no position. It returns
+// ErrRaceAlreadyFinished if the race was already finished, or a
+// *models.TransitionError if it has not started its countdown.
+func (s *RaceService) ForceFinishRace(ctx context.Context, raceID int) (err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.ForceFinishRace", attribute.Int("race.id", raceID))
+	defer tracing.End(span, &err)
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	err = tx.QueryRow(
-		"SELECT COUNT(*) FROM race_participants WHERE race_id = $1 AND status = 'finished'",
-		raceID,
-	).Scan(&finishedParticipants)
+	raceStatus, err := lockRace(ctx, tx, raceID)
+	if err != nil {
+		return err
+	}
+	if raceStatus == models.RaceFinished {
+		return ErrRaceAlreadyFinished
+	}
+
+	if err := transitionRace(ctx, tx, raceID, raceStatus, models.RaceFinished, models.TimelineRaceForceFinished, nil); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE races SET status = $1, finished_at = $2 WHERE id = $3",
+		models.RaceFinished, time.Now(), raceID,
+	)
 	if err != nil {
 		return err
 	}
 
+	if err := s.calculateRaceResults(ctx, tx, raceID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.log(ctx, raceID).Warn("race force-finished")
+	s.publishResults(ctx, raceID)
+	return nil
+}
+
+func (s *RaceService) publishResults(ctx context.Context, raceID int) {
+	results, err := s.GetRaceParticipants(ctx, raceID)
+	if err != nil {
+		s.log(ctx, raceID).Error("failed to load results of finished race", "error", err)
+		return
+	}
+	s.publish(models.RaceEventRaceFinished, raceID, 0, map[string]interface{}{"results": results})
+}
+
+// AbandonIdleRaces marks races that are still waiting for participants and
+// have seen no one join for idleFor as abandoned, recording each transition.
+func (s *RaceService) AbandonIdleRaces(ctx context.Context, idleFor time.Duration) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.AbandonIdleRaces")
+	defer tracing.End(span, &err)
+
+	if err := models.RaceWaiting.TransitionTo(models.RaceAbandoned); err != nil {
+		return 0, err
+	}
+
+	query := `
+		WITH abandoned AS (
+			UPDATE races r
+			SET status = $3, finished_at = CURRENT_TIMESTAMP
+			WHERE r.status = $2
+				AND COALESCE(
+					(SELECT MAX(rp.joined_at) FROM race_participants rp WHERE rp.race_id = r.id),
+					r.created_at
+				) < CURRENT_TIMESTAMP - make_interval(secs => $1)
+			RETURNING r.id
+		)
+		INSERT INTO race_events (race_id, type, entity, from_status, to_status)
+		SELECT id, $4, 'race', $2, $3 FROM abandoned`
+
+	result, err := s.db.ExecContext(ctx, query, idleFor.Seconds(), models.RaceWaiting, models.RaceAbandoned, models.TimelineRaceAbandoned)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteRaceUpdates removes the progress log of races that ended more than
+// retention ago. Results are kept on race_participants.
+func (s *RaceService) DeleteRaceUpdates(ctx context.Context, retention time.Duration) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.DeleteRaceUpdates")
+	defer tracing.End(span, &err)
+
+	query := `
+		DELETE FROM race_updates ru
+		USING races r
+		WHERE ru.race_id = r.id
+			AND r.status IN ('finished', 'abandoned')
+			AND r.finished_at < CURRENT_TIMESTAMP - make_interval(secs => $1)`
+
+	result, err := s.db.ExecContext(ctx, query, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// checkRaceCompletion finishes the race and calculates results once every
+// participant has finished. It reports whether the race was finished.
+func (s *RaceService) checkRaceCompletion(ctx context.Context, tx *sql.Tx, raceID int) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.checkRaceCompletion")
+	defer tracing.End(span, &err)
+
+	var totalParticipants, finishedParticipants int
+	err = tx.QueryRowContext(ctx,
+		"SELECT COUNT(*), COUNT(*) FILTER (WHERE status = $2) FROM race_participants WHERE race_id = $1",
+		raceID, models.ParticipantFinished,
+	).Scan(&totalParticipants, &finishedParticipants)
+	if err != nil {
+		return false, err
+	}
+
 	if totalParticipants == finishedParticipants {
+		// The caller holds the race row lock and only finishes participants
+		// of an active race.
+		if err := transitionRace(ctx, tx, raceID, models.RaceActive, models.RaceFinished, models.TimelineRaceFinished, nil); err != nil {
+			return false, err
+		}
+
 		now := time.Now()
-		_, err = tx.Exec(
-			"UPDATE races SET status = 'finished', finished_at = $1 WHERE id = $2",
-			now, raceID,
+		_, err = tx.ExecContext(ctx,
+			"UPDATE races SET status = $1, finished_at = $2 WHERE id = $3",
+			models.RaceFinished, now, raceID,
 		)
 		if err != nil {
-			return err
+			return false, err
 		}
 
-		err = s.calculateRaceResults(tx, raceID)
+		err = s.calculateRaceResults(ctx, tx, raceID)
 		if err != nil {
-			return err
+			return false, err
 		}
+
+		return true, nil
 	}
 
-	return nil
+	return false, nil
 }
 
-func (s *RaceService) calculateRaceResults(tx *sql.Tx, raceID int) error {
+func (s *RaceService) calculateRaceResults(ctx context.Context, tx *sql.Tx, raceID int) (err error) {
+	ctx, span := tracing.Start(ctx, "RaceService.calculateRaceResults")
+	defer tracing.End(span, &err)
+
 	query := `
 		WITH race_data AS (
 			SELECT r.distance, r.started_at
@@ -287,6 +854,6 @@ func (s *RaceService) calculateRaceResults(tx *sql.Tx, raceID int) error {
 		) pt
 		WHERE rp.id = pt.id`
 
-	_, err := tx.Exec(query, raceID)
+	_, err = tx.ExecContext(ctx, query, raceID)
 	return err
 }
\ No newline at end of file
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"ergracer-api/internal/models"
+)
+
+// lockRace reads the status of a race and locks its row until tx ends, so the
+// transitions of one race are applied one at a time. Participant rows are
+// always locked after their race.
+func lockRace(ctx context.Context, tx *sql.Tx, raceID int) (models.RaceStatus, error) {
+	var status models.RaceStatus
+	err := tx.QueryRowContext(ctx, "SELECT status FROM races WHERE id = $1 FOR UPDATE", raceID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", ErrRaceNotFound
+	}
+	return status, err
+}
+
+// lockParticipant reads the status of a participant and locks their row until
+// tx ends. It returns models.ParticipantNone if the user has not joined.
+func lockParticipant(ctx context.Context, tx *sql.Tx, raceID, userID int) (models.ParticipantStatus, error) {
+	var status models.ParticipantStatus
+	err := tx.QueryRowContext(ctx,
+		"SELECT status FROM race_participants WHERE race_id = $1 AND user_id = $2 FOR UPDATE",
+		raceID, userID,
+	).Scan(&status)
+	if err == sql.ErrNoRows {
+		return models.ParticipantNone, nil
+	}
+	return status, err
+}
+
+// transitionRace checks that a race may move from one status to another and
+// records the change in race_events as an entry of type eventType. The caller
+// updates the race in the same transaction.
+func transitionRace(ctx context.Context, tx *sql.Tx, raceID int, from, to models.RaceStatus, eventType string, data map[string]interface{}) error {
+	if err := from.TransitionTo(to); err != nil {
+		return err
+	}
+	return recordTransition(ctx, tx, raceID, 0, eventType, "race", string(from), string(to), data)
+}
+
+// transitionParticipant is transitionRace for a participant of a race in
+// raceStatus.
+func transitionParticipant(ctx context.Context, tx *sql.Tx, raceID, userID int, from, to models.ParticipantStatus, raceStatus models.RaceStatus, eventType string, data map[string]interface{}) error {
+	if err := from.TransitionTo(to, raceStatus); err != nil {
+		return err
+	}
+	return recordTransition(ctx, tx, raceID, userID, eventType, "participant", string(from), string(to), data)
+}
+
+// recordTransition appends a status change to race_events, the race's
+// timeline. userID is 0 for changes to the race itself, from is empty when the
+// race is created or the participant joins, and data may be nil.
+func recordTransition(ctx context.Context, tx *sql.Tx, raceID, userID int, eventType, entity, from, to string, data map[string]interface{}) error {
+	var details interface{}
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		details = string(b)
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO race_events (race_id, user_id, type, entity, from_status, to_status, data)
+		VALUES ($1, NULLIF($2, 0), $3, $4, NULLIF($5, ''), $6, $7)`,
+		raceID, userID, eventType, entity, from, to, details,
+	)
+	return err
+}
@@ -1,63 +1,127 @@
 package services
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"time"
 
 	"ergracer-api/internal/models"
+	"ergracer-api/internal/tracing"
 	"ergracer-api/internal/utils"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// ErrRefreshTokenReused is returned when a refresh token that has already been
+// rotated is presented again. Its session has been revoked by then, so the
+// client has to log in again.
+var ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+
+// SessionService manages login sessions. Each session is one refresh token
+// family: every refresh replaces the token and bumps the session generation,
+// and the replaced tokens are kept so that replaying one can be detected.
 type SessionService struct {
-	db *sql.DB
+	db                 *sql.DB
+	refreshTokenSecret string
+	revokeAllOnReuse   bool
 }
 
-func NewSessionService(db *sql.DB) *SessionService {
-	return &SessionService{db: db}
+func NewSessionService(db *sql.DB, refreshTokenSecret string, revokeAllOnReuse bool) *SessionService {
+	return &SessionService{
+		db:                 db,
+		refreshTokenSecret: refreshTokenSecret,
+		revokeAllOnReuse:   revokeAllOnReuse,
+	}
 }
 
-func (s *SessionService) CreateSession(userID int, deviceType, userAgent, ipAddress string) (string, error) {
+// CreateSession starts a new session and returns its refresh token and ID.
+func (s *SessionService) CreateSession(ctx context.Context, userID int, deviceType, userAgent, ipAddress string) (_ string, _ int, err error) {
+	ctx, span := tracing.Start(ctx, "SessionService.CreateSession", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
 	refreshToken, err := utils.GenerateRefreshToken()
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
-	refreshTokenHash, err := utils.HashRefreshToken(refreshToken)
-	if err != nil {
-		return "", err
-	}
+	refreshTokenHash := utils.HashRefreshToken(refreshToken, s.refreshTokenSecret)
 	expiresAt := time.Now().Add(7 * 24 * time.Hour) // 7 days
 
 	query := `
 		INSERT INTO sessions (user_id, refresh_token_hash, device_type, user_agent, ip_address, expires_at)
 		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
 	`
 
-	_, err = s.db.Exec(query, userID, refreshTokenHash, deviceType, userAgent, ipAddress, expiresAt)
+	var sessionID int
+	err = s.db.QueryRowContext(ctx, query, userID, refreshTokenHash, deviceType, userAgent, ipAddress, expiresAt).Scan(&sessionID)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
-	return refreshToken, nil
+	return refreshToken, sessionID, nil
 }
 
-func (s *SessionService) ValidateRefreshToken(refreshToken string) (*models.Session, error) {
-	refreshTokenHash, err := utils.HashRefreshToken(refreshToken)
+// GetUserSessions lists the unexpired sessions of a user, most recently used
+// first.
+func (s *SessionService) GetUserSessions(ctx context.Context, userID int) (_ []models.Session, err error) {
+	ctx, span := tracing.Start(ctx, "SessionService.GetUserSessions", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	query := `
+		SELECT id, user_id, device_type, user_agent, ip_address, expires_at, created_at, updated_at
+		FROM sessions
+		WHERE user_id = $1 AND expires_at > NOW()
+		ORDER BY updated_at DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.DeviceType,
+			&session.UserAgent,
+			&session.IPAddress,
+			&session.ExpiresAt,
+			&session.CreatedAt,
+			&session.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+func (s *SessionService) ValidateRefreshToken(ctx context.Context, refreshToken string) (_ *models.Session, err error) {
+	ctx, span := tracing.Start(ctx, "SessionService.ValidateRefreshToken")
+	defer tracing.End(span, &err)
+
+	refreshTokenHash := utils.HashRefreshToken(refreshToken, s.refreshTokenSecret)
 
 	query := `
-		SELECT id, user_id, refresh_token_hash, device_type, user_agent, ip_address, expires_at, created_at, updated_at
+		SELECT id, user_id, refresh_token_hash, generation, device_type, user_agent, ip_address, expires_at, created_at, updated_at
 		FROM sessions
 		WHERE refresh_token_hash = $1 AND expires_at > NOW()
 	`
 
 	var session models.Session
-	err = s.db.QueryRow(query, refreshTokenHash).Scan(
+	err = s.db.QueryRowContext(ctx, query, refreshTokenHash).Scan(
 		&session.ID,
 		&session.UserID,
 		&session.RefreshTokenHash,
+		&session.Generation,
 		&session.DeviceType,
 		&session.UserAgent,
 		&session.IPAddress,
@@ -66,6 +130,9 @@ func (s *SessionService) ValidateRefreshToken(refreshToken string) (*models.Sess
 		&session.UpdatedAt,
 	)
 
+	if err == sql.ErrNoRows {
+		return nil, s.checkRefreshTokenReuse(ctx, refreshTokenHash)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -73,42 +140,175 @@ func (s *SessionService) ValidateRefreshToken(refreshToken string) (*models.Sess
 	return &session, nil
 }
 
-func (s *SessionService) UpdateSession(sessionID int, newRefreshToken string) error {
-	refreshTokenHash, err := utils.HashRefreshToken(newRefreshToken)
+// checkRefreshTokenReuse looks for an unknown refresh token among the tokens
+// already rotated out. A hit means the token was stolen or replayed, so the
+// whole session is revoked. It returns sql.ErrNoRows for tokens that were
+// never issued.
+func (s *SessionService) checkRefreshTokenReuse(ctx context.Context, refreshTokenHash string) error {
+	query := `
+		SELECT s.id, s.user_id
+		FROM session_rotated_tokens t
+		JOIN sessions s ON s.id = t.session_id
+		WHERE t.token_hash = $1
+	`
+
+	var sessionID, userID int
+	err := s.db.QueryRowContext(ctx, query, refreshTokenHash).Scan(&sessionID, &userID)
 	if err != nil {
 		return err
 	}
+
+	if err := s.revokeFamily(ctx, sessionID, userID); err != nil {
+		return err
+	}
+
+	return ErrRefreshTokenReused
+}
+
+func (s *SessionService) revokeFamily(ctx context.Context, sessionID, userID int) error {
+	if s.revokeAllOnReuse {
+		return s.DeleteUserSessions(ctx, userID)
+	}
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1`, sessionID)
+	return err
+}
+
+// UpdateSession rotates the refresh token of a session validated with
+// ValidateRefreshToken. The previous token is remembered so that presenting
+// it again revokes the session.
+func (s *SessionService) UpdateSession(ctx context.Context, session *models.Session, newRefreshToken string) (err error) {
+	ctx, span := tracing.Start(ctx, "SessionService.UpdateSession")
+	defer tracing.End(span, &err)
+
+	refreshTokenHash := utils.HashRefreshToken(newRefreshToken, s.refreshTokenSecret)
 	expiresAt := time.Now().Add(7 * 24 * time.Hour) // 7 days
 
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		UPDATE sessions
-		SET refresh_token_hash = $1, expires_at = $2, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $3
+		SET refresh_token_hash = $1, generation = generation + 1, expires_at = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND refresh_token_hash = $4
 	`
 
-	_, err = s.db.Exec(query, refreshTokenHash, expiresAt, sessionID)
-	return err
+	result, err := tx.ExecContext(ctx, query, refreshTokenHash, expiresAt, session.ID, session.RefreshTokenHash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		// Another request rotated the same token first.
+		tx.Rollback()
+		if err := s.revokeFamily(ctx, session.ID, session.UserID); err != nil {
+			return err
+		}
+		return ErrRefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO session_rotated_tokens (token_hash, session_id, generation) VALUES ($1, $2, $3)",
+		session.RefreshTokenHash, session.ID, session.Generation,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SessionActive reports whether a session of the user exists and has not
+// expired. Access tokens carry the ID of their session, so this is how a
+// revoked session stops working before its access token expires.
+func (s *SessionService) SessionActive(ctx context.Context, userID, sessionID int) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "SessionService.SessionActive", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	query := `SELECT EXISTS(SELECT 1 FROM sessions WHERE id = $1 AND user_id = $2 AND expires_at > NOW())`
+
+	var active bool
+	err = s.db.QueryRowContext(ctx, query, sessionID, userID).Scan(&active)
+	if err != nil {
+		return false, err
+	}
+
+	return active, nil
 }
 
-func (s *SessionService) DeleteExpiredSessions() error {
+// DeleteExpiredSessions removes sessions whose refresh token has expired and
+// returns how many were removed.
+func (s *SessionService) DeleteExpiredSessions(ctx context.Context) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "SessionService.DeleteExpiredSessions")
+	defer tracing.End(span, &err)
+
 	query := `DELETE FROM sessions WHERE expires_at <= NOW()`
-	_, err := s.db.Exec(query)
+	result, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SessionService) DeleteSession(ctx context.Context, refreshToken string) (err error) {
+	ctx, span := tracing.Start(ctx, "SessionService.DeleteSession")
+	defer tracing.End(span, &err)
+
+	refreshTokenHash := utils.HashRefreshToken(refreshToken, s.refreshTokenSecret)
+
+	query := `DELETE FROM sessions WHERE refresh_token_hash = $1`
+	_, err = s.db.ExecContext(ctx, query, refreshTokenHash)
 	return err
 }
 
-func (s *SessionService) DeleteSession(refreshToken string) error {
-	refreshTokenHash, err := utils.HashRefreshToken(refreshToken)
+// DeleteUserSession revokes one session of a user. It returns sql.ErrNoRows if
+// the user has no such session.
+func (s *SessionService) DeleteUserSession(ctx context.Context, userID, sessionID int) (err error) {
+	ctx, span := tracing.Start(ctx, "SessionService.DeleteUserSession", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	query := `DELETE FROM sessions WHERE id = $1 AND user_id = $2`
+	result, err := s.db.ExecContext(ctx, query, sessionID, userID)
 	if err != nil {
 		return err
 	}
 
-	query := `DELETE FROM sessions WHERE refresh_token_hash = $1`
-	_, err = s.db.Exec(query, refreshTokenHash)
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteOtherUserSessions revokes every session of a user except the given
+// one.
+func (s *SessionService) DeleteOtherUserSessions(ctx context.Context, userID, keepSessionID int) (err error) {
+	ctx, span := tracing.Start(ctx, "SessionService.DeleteOtherUserSessions", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	query := `DELETE FROM sessions WHERE user_id = $1 AND id <> $2`
+	_, err = s.db.ExecContext(ctx, query, userID, keepSessionID)
 	return err
 }
 
-func (s *SessionService) DeleteUserSessions(userID int) error {
+func (s *SessionService) DeleteUserSessions(ctx context.Context, userID int) (err error) {
+	ctx, span := tracing.Start(ctx, "SessionService.DeleteUserSessions", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
 	query := `DELETE FROM sessions WHERE user_id = $1`
-	_, err := s.db.Exec(query, userID)
+	_, err = s.db.ExecContext(ctx, query, userID)
 	return err
 }
@@ -1,11 +1,45 @@
 package services
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"ergracer-api/internal/models"
+	"ergracer-api/internal/tracing"
 	"ergracer-api/internal/utils"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// passwordResetTTL is how long a password reset token stays valid.
+	passwordResetTTL = time.Hour
+
+	// usernameChangeCooldown is how long a user has to wait between username
+	// changes, so names cannot be grabbed and released at will.
+	usernameChangeCooldown = 30 * 24 * time.Hour
+
+	// verificationTTL is how long an email verification token stays valid.
+	verificationTTL = 24 * time.Hour
+
+	// verificationResendCooldown is the minimum time between two
+	// verification emails for the same account.
+	verificationResendCooldown = time.Minute
+)
+
+var (
+	ErrIncorrectPassword         = errors.New("current password is incorrect")
+	ErrEmailTaken                = errors.New("email address is already in use")
+	ErrUsernameTaken             = errors.New("username is already taken")
+	ErrUsernameCooldown          = errors.New("username was changed too recently")
+	ErrVerificationTokenExpired  = errors.New("verification token has expired, please request a new one")
+	ErrAlreadyVerified           = errors.New("email is already verified")
+	ErrVerificationResendTooSoon = errors.New("verification email was sent too recently")
 )
 
 type UserService struct {
@@ -16,7 +50,10 @@ func NewUserService(db *sql.DB) *UserService {
 	return &UserService{db: db}
 }
 
-func (s *UserService) CreateUser(email, username, password string) (*models.User, error) {
+func (s *UserService) CreateUser(ctx context.Context, email, username, password, locale string) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.CreateUser")
+	defer tracing.End(span, &err)
+
 	hashedPassword, err := utils.HashPassword(password)
 	if err != nil {
 		return nil, err
@@ -29,12 +66,12 @@ func (s *UserService) CreateUser(email, username, password string) (*models.User
 
 	var user models.User
 	query := `
-		INSERT INTO users (email, username, password_hash, email_verify_token)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, email, username, email_verified, created_at, updated_at`
+		INSERT INTO users (email, username, password_hash, locale, email_verify_token, email_verify_expires_at, email_verify_sent_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+		RETURNING id, email, username, email_verified, locale, created_at, updated_at`
 	
-	err = s.db.QueryRow(query, email, username, hashedPassword, token).Scan(
-		&user.ID, &user.Email, &user.Username, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt,
+	err = s.db.QueryRowContext(ctx, query, email, username, hashedPassword, locale, token, time.Now().Add(verificationTTL)).Scan(
+		&user.ID, &user.Email, &user.Username, &user.EmailVerified, &user.Locale, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -44,12 +81,15 @@ func (s *UserService) CreateUser(email, username, password string) (*models.User
 	return &user, nil
 }
 
-func (s *UserService) GetUserByEmail(email string) (*models.User, error) {
+func (s *UserService) GetUserByEmail(ctx context.Context, email string) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetUserByEmail")
+	defer tracing.End(span, &err)
+
 	var user models.User
-	query := `SELECT id, email, username, password_hash, email_verified, created_at, updated_at FROM users WHERE email = $1`
+	query := `SELECT id, email, username, password_hash, email_verified, locale, disabled_at, created_at, updated_at FROM users WHERE email = $1`
 	
-	err := s.db.QueryRow(query, email).Scan(
-		&user.ID, &user.Email, &user.Username, &user.PasswordHash, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt,
+	err = s.db.QueryRowContext(ctx, query, email).Scan(
+		&user.ID, &user.Email, &user.Username, &user.PasswordHash, &user.EmailVerified, &user.Locale, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -58,12 +98,15 @@ func (s *UserService) GetUserByEmail(email string) (*models.User, error) {
 	return &user, nil
 }
 
-func (s *UserService) GetUserByID(id int) (*models.User, error) {
+func (s *UserService) GetUserByID(ctx context.Context, id int) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetUserByID", attribute.Int("user.id", id))
+	defer tracing.End(span, &err)
+
 	var user models.User
-	query := `SELECT id, email, username, email_verified, created_at, updated_at FROM users WHERE id = $1`
+	query := `SELECT id, email, username, email_verified, pending_email, locale, disabled_at, created_at, updated_at FROM users WHERE id = $1`
 	
-	err := s.db.QueryRow(query, id).Scan(
-		&user.ID, &user.Email, &user.Username, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt,
+	err = s.db.QueryRowContext(ctx, query, id).Scan(
+		&user.ID, &user.Email, &user.Username, &user.EmailVerified, &user.PendingEmail, &user.Locale, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -72,10 +115,56 @@ func (s *UserService) GetUserByID(id int) (*models.User, error) {
 	return &user, nil
 }
 
-func (s *UserService) VerifyEmail(token string) error {
-	query := `UPDATE users SET email_verified = true, email_verify_token = NULL WHERE email_verify_token = $1`
-	result, err := s.db.Exec(query, token)
+func (s *UserService) GetUserByUsername(ctx context.Context, username string) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetUserByUsername")
+	defer tracing.End(span, &err)
+
+	var user models.User
+	query := `SELECT id, email, username, email_verified, pending_email, locale, disabled_at, created_at, updated_at FROM users WHERE username = $1`
+
+	err = s.db.QueryRowContext(ctx, query, username).Scan(
+		&user.ID, &user.Email, &user.Username, &user.EmailVerified, &user.PendingEmail, &user.Locale, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// VerifyEmail confirms the address a verification token was sent to. For a
+// pending email change this is also when the new address replaces the old one.
+func (s *UserService) VerifyEmail(ctx context.Context, token string) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.VerifyEmail")
+	defer tracing.End(span, &err)
+
+	var expiresAt *time.Time
+	err = s.db.QueryRowContext(ctx,
+		"SELECT email_verify_expires_at FROM users WHERE email_verify_token = $1",
+		token,
+	).Scan(&expiresAt)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("invalid verification token")
+		}
+		return err
+	}
+
+	if expiresAt != nil && time.Now().After(*expiresAt) {
+		return ErrVerificationTokenExpired
+	}
+
+	query := `
+		UPDATE users
+		SET email = COALESCE(pending_email, email), pending_email = NULL,
+			email_verified = true, email_verify_token = NULL, email_verify_expires_at = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE email_verify_token = $1`
+	result, err := s.db.ExecContext(ctx, query, token)
+	if err != nil {
+		if isUniqueViolation(err, "users_email_key") {
+			return ErrEmailTaken
+		}
 		return err
 	}
 
@@ -91,8 +180,55 @@ func (s *UserService) VerifyEmail(token string) error {
 	return nil
 }
 
-func (s *UserService) AuthenticateUser(email, password string) (*models.User, error) {
-	user, err := s.GetUserByEmail(email)
+// ResendVerification issues a fresh verification token for an unverified
+// account, invalidating the previous one. It returns sql.ErrNoRows if no
+// account uses the email.
+func (s *UserService) ResendVerification(ctx context.Context, email string) (_ *models.User, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.ResendVerification")
+	defer tracing.End(span, &err)
+
+	user, err := s.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if user.EmailVerified {
+		return nil, "", ErrAlreadyVerified
+	}
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := `
+		UPDATE users
+		SET email_verify_token = $1, email_verify_expires_at = $2, email_verify_sent_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND (email_verify_sent_at IS NULL OR email_verify_sent_at < $4)`
+
+	now := time.Now()
+	result, err := s.db.ExecContext(ctx, query, token, now.Add(verificationTTL), user.ID, now.Add(-verificationResendCooldown))
+	if err != nil {
+		return nil, "", err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if rowsAffected == 0 {
+		return nil, "", ErrVerificationResendTooSoon
+	}
+
+	return user, token, nil
+}
+
+func (s *UserService) AuthenticateUser(ctx context.Context, email, password string) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.AuthenticateUser")
+	defer tracing.End(span, &err)
+
+	user, err := s.GetUserByEmail(ctx, email)
 	if err != nil {
 		return nil, fmt.Errorf("invalid credentials")
 	}
@@ -105,5 +241,302 @@ func (s *UserService) AuthenticateUser(email, password string) (*models.User, er
 		return nil, fmt.Errorf("email not verified")
 	}
 
+	if user.DisabledAt != nil {
+		return nil, fmt.Errorf("account disabled")
+	}
+
 	return user, nil
-}
\ No newline at end of file
+}
+
+// MarkEmailVerified verifies a user's current email without a token, for use
+// by administrators. A pending email change is left untouched.
+func (s *UserService) MarkEmailVerified(ctx context.Context, userID int) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.MarkEmailVerified", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	query := `
+		UPDATE users
+		SET email_verified = true,
+			email_verify_token = CASE WHEN pending_email IS NULL THEN NULL ELSE email_verify_token END,
+			email_verify_expires_at = CASE WHEN pending_email IS NULL THEN NULL ELSE email_verify_expires_at END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`
+	return s.execForUser(ctx, query, userID)
+}
+
+// SetDisabled disables or re-enables a user. Disabled users cannot log in;
+// callers should also revoke their sessions.
+func (s *UserService) SetDisabled(ctx context.Context, userID int, disabled bool) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.SetDisabled", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	query := `
+		UPDATE users
+		SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, CURRENT_TIMESTAMP) END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`
+	return s.execForUser(ctx, query, userID, disabled)
+}
+
+// SetAdmin grants or revokes access to the admin endpoints.
+func (s *UserService) SetAdmin(ctx context.Context, userID int, admin bool) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.SetAdmin", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	query := `UPDATE users SET is_admin = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+	return s.execForUser(ctx, query, userID, admin)
+}
+
+func (s *UserService) IsAdmin(ctx context.Context, userID int) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.IsAdmin", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	var isAdmin bool
+	err = s.db.QueryRowContext(ctx,
+		"SELECT is_admin FROM users WHERE id = $1 AND disabled_at IS NULL",
+		userID,
+	).Scan(&isAdmin)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return isAdmin, err
+}
+
+// DeleteUnverifiedUsers removes accounts that were never verified and are
+// older than maxAge, freeing their email and username. Such accounts cannot
+// log in, so they have no other data.
+func (s *UserService) DeleteUnverifiedUsers(ctx context.Context, maxAge time.Duration) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.DeleteUnverifiedUsers")
+	defer tracing.End(span, &err)
+
+	result, err := s.db.ExecContext(ctx,
+		"DELETE FROM users WHERE email_verified = false AND created_at < CURRENT_TIMESTAMP - make_interval(secs => $1)",
+		maxAge.Seconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// execForUser runs an update of a single user, returning sql.ErrNoRows if the
+// user does not exist.
+func (s *UserService) execForUser(ctx context.Context, query string, userID int, args ...interface{}) error {
+	result, err := s.db.ExecContext(ctx, query, append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CreatePasswordResetToken issues a single-use password reset token for the
+// user with the given email, replacing any earlier unused token. It returns
+// sql.ErrNoRows if no such user exists.
+func (s *UserService) CreatePasswordResetToken(ctx context.Context, email string) (_ *models.User, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.CreatePasswordResetToken")
+	defer tracing.End(span, &err)
+
+	user, err := s.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM password_reset_tokens WHERE user_id = $1 AND used_at IS NULL", user.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+		user.ID, utils.HashToken(token), time.Now().Add(passwordResetTTL),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+
+	return user, token, nil
+}
+
+// ResetPassword sets a new password using a reset token and marks the token
+// as used. It returns the ID of the user whose password was changed.
+func (s *UserService) ResetPassword(ctx context.Context, token, newPassword string) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.ResetPassword")
+	defer tracing.End(span, &err)
+
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var tokenID, userID int
+	query := `
+		SELECT id, user_id FROM password_reset_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, utils.HashToken(token)).Scan(&tokenID, &userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("invalid or expired reset token")
+		}
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE users SET password_hash = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+		hashedPassword, userID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1", tokenID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (s *UserService) ChangePassword(ctx context.Context, userID int, currentPassword, newPassword string) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.ChangePassword", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	var passwordHash string
+	err = s.db.QueryRowContext(ctx, "SELECT password_hash FROM users WHERE id = $1", userID).Scan(&passwordHash)
+	if err != nil {
+		return err
+	}
+
+	if !utils.CheckPassword(currentPassword, passwordHash) {
+		return ErrIncorrectPassword
+	}
+
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		"UPDATE users SET password_hash = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+		hashedPassword, userID,
+	)
+	return err
+}
+
+// RequestEmailChange records newEmail as the pending email of the user and
+// returns the token that confirms it. The current email stays in use until
+// the token is passed to VerifyEmail.
+func (s *UserService) RequestEmailChange(ctx context.Context, userID int, newEmail string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.RequestEmailChange", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	var taken bool
+	err = s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)", newEmail).Scan(&taken)
+	if err != nil {
+		return "", err
+	}
+
+	if taken {
+		return "", ErrEmailTaken
+	}
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	query := `
+		UPDATE users
+		SET pending_email = $1, email_verify_token = $2, email_verify_expires_at = $3,
+			email_verify_sent_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4`
+
+	_, err = s.db.ExecContext(ctx, query, newEmail, token, time.Now().Add(verificationTTL), userID)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *UserService) ChangeUsername(ctx context.Context, userID int, newUsername string) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.ChangeUsername", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	query := `
+		UPDATE users
+		SET username = $1, username_changed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND (username_changed_at IS NULL OR username_changed_at < $3)`
+
+	result, err := s.db.ExecContext(ctx, query, newUsername, userID, time.Now().Add(-usernameChangeCooldown))
+	if err != nil {
+		if isUniqueViolation(err, "users_username_key") {
+			return ErrUsernameTaken
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrUsernameCooldown
+	}
+
+	return nil
+}
+
+// SetLocale changes the language the user's emails are sent in.
+func (s *UserService) SetLocale(ctx context.Context, userID int, locale string) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.SetLocale", attribute.Int("user.id", userID))
+	defer tracing.End(span, &err)
+
+	_, err = s.db.ExecContext(ctx,
+		"UPDATE users SET locale = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+		locale, userID,
+	)
+	return err
+}
+
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
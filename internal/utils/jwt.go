@@ -1,23 +1,26 @@
 package utils
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Claims struct {
-	UserID int `json:"user_id"`
+	UserID    int `json:"user_id"`
+	SessionID int `json:"session_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateJWT(userID int, secret string) (string, error) {
+func GenerateJWT(userID, sessionID int, secret string) (string, error) {
 	claims := &Claims{
-		UserID: userID,
+		UserID:    userID,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(30 * time.Minute)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -28,7 +31,7 @@ func GenerateJWT(userID int, secret string) (string, error) {
 	return token.SignedString([]byte(secret))
 }
 
-func ValidateJWT(tokenString, secret string) (int, error) {
+func ValidateJWT(tokenString, secret string) (*Claims, error) {
 	claims := &Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
@@ -36,18 +39,18 @@ func ValidateJWT(tokenString, secret string) (int, error) {
 	})
 
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return 0, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
 	if token.Method != jwt.SigningMethodHS256 {
-		return 0, errors.New("incorrect signing method")
+		return nil, errors.New("incorrect signing method")
 	}
 
-	return claims.UserID, nil
+	return claims, nil
 }
 
 func GenerateRefreshToken() (string, error) {
@@ -58,8 +61,11 @@ func GenerateRefreshToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-func HashRefreshToken(token string) (string, error) {
-
-	bytes, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.DefaultCost)
-	return string(bytes), err
+// HashRefreshToken returns a keyed HMAC-SHA256 digest of a refresh token.
+// Unlike a password hash it is deterministic, so sessions can be looked up by
+// it, while the secret keeps a leaked sessions table from being usable.
+func HashRefreshToken(token, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
 }
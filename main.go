@@ -1,36 +1,100 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"ergracer-api/internal/api"
+	"ergracer-api/internal/cli"
 	"ergracer-api/internal/config"
 	"ergracer-api/internal/database"
+	"ergracer-api/internal/logging"
+	"ergracer-api/internal/mailer"
+	"ergracer-api/internal/tracing"
 )
 
 func main() {
-	cfg := config.Load()
-	log.Printf("the db URL: %s", cfg.DatabaseURL())
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	if args := os.Args[1:]; len(args) > 0 && args[0] != "serve" {
+		if err := cli.Run(cfg, args); err != nil {
+			if !errors.Is(err, cli.ErrUsage) {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	logger, err := logging.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		log.Fatal("Invalid log configuration: ", err)
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal(logger, "failed to set up tracing", err)
+	}
+
 	db, err := database.Connect(cfg.DatabaseURL())
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		fatal(logger, "failed to connect to database", err)
 	}
-	defer db.Close()
 
 	if err := database.Migrate(db); err != nil {
-		log.Fatal("Failed to run migrations:", err)
+		fatal(logger, "failed to run migrations", err)
 	}
 
-	server := api.NewServer(db, cfg)
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		fatal(logger, "failed to set up mailer", err)
+	}
+
+	server, err := api.NewServer(db, cfg, mail, logger)
+	if err != nil {
+		fatal(logger, "failed to set up server", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// A second signal kills the process instead of waiting for the drain.
+		stop()
+	}()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	logger.Info("server starting", "addr", cfg.ListenAddr(), "tls", cfg.TLSEnabled())
+	runErr := server.Run(ctx)
+	if runErr != nil {
+		logger.Error("server error", "error", runErr)
 	}
 
-	log.Printf("Server starting on port %s", port)
-	if err := server.Start(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	if err := db.Close(); err != nil {
+		logger.Error("failed to close database", "error", err)
 	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Error("failed to flush traces", "error", err)
+	}
+	if runErr != nil {
+		os.Exit(1)
+	}
+}
+
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
 }